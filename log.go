@@ -228,14 +228,74 @@ type Logger interface {
 	JSON() []byte
 }
 
-// Logs returns the list of logs stored
-func (router *Router) Logs() []Log {
+// DefaultLogCapacity is the maximum number of logs kept in memory
+// by a new Router, see Router.SetLogCapacity
+var DefaultLogCapacity = 10000
+
+// SetLogCapacity sets the maximum number of logs kept in memory: when
+// the limit is reached, the oldest logs are dropped to make room for
+// the new ones. If n is zero or negative, there is no limit
+func (router *Router) SetLogCapacity(n int) {
+	router.mLog.Lock()
+	defer router.mLog.Unlock()
+
+	logs := router.orderedLogs()
+	if n > 0 && len(logs) > n {
+		logs = logs[len(logs)-n:]
+	}
+
+	router.logs = logs
+	router.logStart = 0
+	router.logCap = n
+}
+
+// LogCapacity returns the maximum number of logs kept in memory,
+// see Router.SetLogCapacity
+func (router *Router) LogCapacity() int {
+	router.mLog.Lock()
+	defer router.mLog.Unlock()
+
+	return router.logCap
+}
+
+// LogCount returns the number of logs currently kept in memory
+func (router *Router) LogCount() int {
+	router.mLog.Lock()
+	defer router.mLog.Unlock()
+
+	return len(router.logs)
+}
+
+// storeLog saves the log in memory, dropping the oldest one
+// if the capacity is reached. It must be called with the lock held
+func (router *Router) storeLog(log Log) {
+	if router.logCap <= 0 || len(router.logs) < router.logCap {
+		router.logs = append(router.logs, log)
+		return
+	}
+
+	router.logs[router.logStart] = log
+	router.logStart = (router.logStart + 1) % len(router.logs)
+}
+
+// orderedLogs returns a copy of the logs stored, from the oldest
+// to the newest. It must be called with the lock held
+func (router *Router) orderedLogs() []Log {
 	logs := make([]Log, 0, len(router.logs))
-	logs = append(logs, router.logs...)
+	logs = append(logs, router.logs[router.logStart:]...)
+	logs = append(logs, router.logs[:router.logStart]...)
 
 	return logs
 }
 
+// Logs returns the list of logs stored
+func (router *Router) Logs() []Log {
+	router.mLog.Lock()
+	defer router.mLog.Unlock()
+
+	return router.orderedLogs()
+}
+
 // JSON returns the list of logs stored in JSON format (see Log.JSON() method)
 func (router *Router) JSON() []byte {
 	res := make([]byte, 0)
@@ -243,7 +303,7 @@ func (router *Router) JSON() []byte {
 
 	first := true
 
-	for _, log := range router.logs {
+	for _, log := range router.Logs() {
 		if !first {
 			res = append(res, []byte(",")...)
 		} else {
@@ -272,6 +332,7 @@ func (router *Router) Log(level LogLevel, message string, extra ...any) {
 		), level, t,
 		message, fmt.Sprint(extra...),
 	}
+	router.storeLog(log)
 
 	if router.logFile != nil {
 		if log.Extra != "" {
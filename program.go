@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sync"
 )
 
 // program wraps the default *exec.Cmd structure and makes easier the
@@ -24,6 +25,8 @@ type program struct {
 	running          bool
 	in               io.Reader
 	out              io.Writer
+	capture          *ringBuffer
+	captureM         sync.Mutex
 }
 
 // newProgram creates a new program with the diven parameters
@@ -61,9 +64,18 @@ func (p *program) start() (<-chan error, error) {
 		p.exec.Dir = p.dir
 	}
 
+	out := p.out
+	if capture := p.captureBuffer(); capture != nil {
+		if out == nil {
+			out = capture
+		} else {
+			out = io.MultiWriter(out, capture)
+		}
+	}
+
 	p.exec.Stdin = p.in
-	p.exec.Stdout = p.out
-	p.exec.Stderr = p.out
+	p.exec.Stdout = out
+	p.exec.Stderr = out
 
 	err := p.exec.Start()
 	if err != nil {
@@ -277,6 +289,108 @@ func (tm *TaskManager) StopAllPrograms() {
 	}
 }
 
+// SetProgramOutputCapture enables the capture of the last size bytes of the output
+// (both standard output and standard error) of the program with the given name,
+// in addition to the out writer provided on creation, so that it can be retreived
+// for debugging with ProgramOutput without growing unbounded. A size <= 0 disables
+// the capture. The change is applied the next time the program is started
+func (tm *TaskManager) SetProgramOutputCapture(name string, size int) error {
+	p, err := tm.findProgram(name)
+	if err != nil {
+		return err
+	}
+
+	var capture *ringBuffer
+	if size > 0 {
+		capture = newRingBuffer(size)
+	}
+
+	p.captureM.Lock()
+	p.capture = capture
+	p.captureM.Unlock()
+
+	return nil
+}
+
+// ProgramOutput returns the last bytes of the output of the program with
+// the given name, if the capture is enabled (see SetProgramOutputCapture)
+func (tm *TaskManager) ProgramOutput(name string) ([]byte, error) {
+	p, err := tm.findProgram(name)
+	if err != nil {
+		return nil, err
+	}
+
+	capture := p.captureBuffer()
+	if capture == nil {
+		return nil, fmt.Errorf("program \"%s\" output capture is not enabled", name)
+	}
+
+	return capture.Bytes(), nil
+}
+
+// captureBuffer returns the buffer capturing the program output, or nil
+// if the capture is not enabled
+func (p *program) captureBuffer() *ringBuffer {
+	p.captureM.Lock()
+	defer p.captureM.Unlock()
+
+	return p.capture
+}
+
+// ringBuffer is an io.Writer that keeps only the last
+// bytes written, up to its size. It's safe for concurrent use
+type ringBuffer struct {
+	m     sync.Mutex
+	data  []byte
+	start int
+	full  bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, size)}
+}
+
+// Write is used to implement the io.Writer interface
+func (rb *ringBuffer) Write(p []byte) (int, error) {
+	rb.m.Lock()
+	defer rb.m.Unlock()
+
+	n := len(p)
+	if n >= len(rb.data) {
+		copy(rb.data, p[n-len(rb.data):])
+		rb.start = 0
+		rb.full = true
+		return n, nil
+	}
+
+	written := copy(rb.data[rb.start:], p)
+	if written < n {
+		copy(rb.data, p[written:])
+		rb.full = true
+	}
+
+	rb.start = (rb.start + n) % len(rb.data)
+	if rb.start == 0 {
+		rb.full = true
+	}
+
+	return n, nil
+}
+
+// Bytes returns a copy of the bytes stored, from the oldest to the newest
+func (rb *ringBuffer) Bytes() []byte {
+	rb.m.Lock()
+	defer rb.m.Unlock()
+
+	if !rb.full {
+		return append([]byte(nil), rb.data[:rb.start]...)
+	}
+
+	b := make([]byte, 0, len(rb.data))
+	b = append(b, rb.data[rb.start:]...)
+	return append(b, rb.data[:rb.start]...)
+}
+
 // checkProgramName checks if a new program can be created with the giver name. If there is an
 // already registered program with the same name, it returns false, otherwise
 // it returns true
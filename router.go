@@ -19,6 +19,8 @@ type Router struct {
 	TaskMgr   			*TaskManager
 	logFile 			*os.File
 	logs      			[]Log
+	logStart 			int
+	logCap 				int
 	mLog         		*sync.Mutex
 }
 
@@ -32,6 +34,7 @@ func NewRouter(logFile *os.File, serverPath string) (router *Router, err error)
 		router.logFile = logFile
 	}
 	router.mLog = new(sync.Mutex)
+	router.logCap = DefaultLogCapacity
 
 	if serverPath == "" {
 		serverPath, err = os.Getwd()
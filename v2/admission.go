@@ -0,0 +1,181 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdmissionStats reports the state of the admission queue of
+// a subdomain, see Subdomain.SetAdmissionQueue
+type AdmissionStats struct {
+	Active    int // Active is the number of requests being served
+	Queued    int // Queued is the number of requests waiting to be admitted
+	MaxQueued int // MaxQueued is the highest number of requests queued at the same time
+	Admitted  uint64
+	Rejected  uint64        // Rejected counts the requests refused because the queue was full
+	TimedOut  uint64        // TimedOut counts the requests that exceeded the wait or were canceled
+	TotalWait time.Duration // TotalWait is the sum of the wait of every admitted request
+}
+
+// AverageWait returns the average time an admitted request waited in the queue
+func (stats AdmissionStats) AverageWait() time.Duration {
+	if stats.Admitted == 0 {
+		return 0
+	}
+	return stats.TotalWait / time.Duration(stats.Admitted)
+}
+
+// admissionQueue limits the number of requests served at the same time,
+// making the exceeding ones wait in a bounded FIFO queue
+type admissionQueue struct {
+	m             sync.Mutex
+	maxConcurrent int
+	maxQueue      int
+	maxWait       time.Duration
+	active        int
+	waiting       *list.List
+	stats         AdmissionStats
+}
+
+// acquire waits for a slot to be available and reports whether the
+// request was admitted and how much it waited. When the request is
+// admitted, release must be called when it has been served
+func (q *admissionQueue) acquire(ctx context.Context) (time.Duration, bool) {
+	q.m.Lock()
+	if q.active < q.maxConcurrent && q.waiting.Len() == 0 {
+		q.active++
+		q.stats.Admitted++
+		q.m.Unlock()
+		return 0, true
+	}
+
+	if q.waiting.Len() >= q.maxQueue {
+		q.stats.Rejected++
+		q.m.Unlock()
+		return 0, false
+	}
+
+	ready := make(chan struct{})
+	elem := q.waiting.PushBack(ready)
+	if q.waiting.Len() > q.stats.MaxQueued {
+		q.stats.MaxQueued = q.waiting.Len()
+	}
+	q.m.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if q.maxWait > 0 {
+		timer := time.NewTimer(q.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	wait := time.Since(start)
+	select {
+	case <-ready:
+		q.stats.Admitted++
+		q.stats.TotalWait += wait
+		return wait, true
+	default:
+		// the slot was not handed over, so the request is still in the queue
+		q.waiting.Remove(elem)
+		q.stats.TimedOut++
+		return wait, false
+	}
+}
+
+// release frees the slot of a request, handing it over to
+// the first request in the queue, if any
+func (q *admissionQueue) release() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if front := q.waiting.Front(); front != nil {
+		q.waiting.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+
+	q.active--
+}
+
+func (q *admissionQueue) getStats() AdmissionStats {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	stats := q.stats
+	stats.Active = q.active
+	stats.Queued = q.waiting.Len()
+	return stats
+}
+
+// SetAdmissionQueue limits the number of requests served at the same time by the
+// subdomain to maxConcurrent: the exceeding requests wait in a FIFO queue of at most
+// maxQueue elements for up to maxWait (zero or negative means no limit other than the
+// request being canceled by the client). When the queue is full or the wait is exceeded,
+// the request is rejected with a 503 Service Unavailable error. The time spent in the
+// queue is reported in the Server-Timing header (see Route.Timing) and the queue metrics
+// can be read with Subdomain.AdmissionStats. A maxConcurrent of zero or less removes the
+// limit. This should be called before starting the server
+func (sd *Subdomain) SetAdmissionQueue(maxConcurrent, maxQueue int, maxWait time.Duration) {
+	if maxConcurrent <= 0 {
+		sd.admission = nil
+		return
+	}
+
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+
+	sd.admission = &admissionQueue{
+		maxConcurrent: maxConcurrent,
+		maxQueue:      maxQueue,
+		maxWait:       maxWait,
+		waiting:       list.New(),
+	}
+}
+
+// AdmissionStats returns the metrics of the admission queue of the subdomain,
+// see Subdomain.SetAdmissionQueue. If the queue is not set, the result is empty
+func (sd *Subdomain) AdmissionStats() AdmissionStats {
+	if sd.admission == nil {
+		return AdmissionStats{}
+	}
+	return sd.admission.getStats()
+}
+
+// admit waits for the request to be admitted by the admission queue of the
+// subdomain, if set, and reports whether the request can be served: if not,
+// the error is already sent. When admitted, the returned function must be called
+// after the request has been served
+func (route *Route) admit() (func(), bool) {
+	q := route.Subdomain.admission
+	if q == nil {
+		return func() {}, true
+	}
+
+	wait, ok := q.acquire(route.R.Context())
+	if wait > 0 {
+		route.W.timings = append(route.W.timings, fmt.Sprintf("queue;dur=%.3f", float64(wait.Microseconds())/1000))
+	}
+	if !ok {
+		route.W.Header().Set("Retry-After", "1")
+		route.Error(http.StatusServiceUnavailable, "Server busy, retry later", "Request rejected by the admission queue")
+		return nil, false
+	}
+
+	return q.release, true
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CacheControl is a builder for the Cache-Control HTTP header, so that
+// its value does not have to be written by hand. Every method returns
+// the same CacheControl, so that the calls can be chained, for example:
+//
+//	route.CacheControl().Public().MaxAge(time.Hour).Immutable().Set()
+//
+// The builder can also be used without a Route (see NewCacheControl)
+// and the final header value can be retreived with the String method
+type CacheControl struct {
+	route      *Route
+	directives []string
+}
+
+// NewCacheControl returns an empty CacheControl not linked to any Route,
+// so the Set method will do nothing
+func NewCacheControl() *CacheControl {
+	return new(CacheControl)
+}
+
+// CacheControl returns a new CacheControl builder linked to the Route:
+// when the Set method is called, the header will be set in the response
+func (route *Route) CacheControl() *CacheControl {
+	return &CacheControl{route: route}
+}
+
+// NoCache sets the Cache-Control header to prevent the response from being
+// stored or reused by any cache without revalidation
+func (route *Route) NoCache() {
+	route.CacheControl().NoCache().NoStore().MustRevalidate().Set()
+}
+
+// add adds the directive, replacing the previous one with the same name
+func (cc *CacheControl) add(directive string) *CacheControl {
+	name, _, _ := strings.Cut(directive, "=")
+
+	for i, d := range cc.directives {
+		dName, _, _ := strings.Cut(d, "=")
+		if dName == name {
+			cc.directives[i] = directive
+			return cc
+		}
+	}
+
+	cc.directives = append(cc.directives, directive)
+	return cc
+}
+
+// addDuration adds a directive with the duration expressed in seconds
+func (cc *CacheControl) addDuration(name string, d time.Duration) *CacheControl {
+	if d < 0 {
+		d = 0
+	}
+
+	return cc.add(fmt.Sprintf("%s=%d", name, int64(d/time.Second)))
+}
+
+// Public adds the public directive
+func (cc *CacheControl) Public() *CacheControl {
+	return cc.add("public")
+}
+
+// Private adds the private directive
+func (cc *CacheControl) Private() *CacheControl {
+	return cc.add("private")
+}
+
+// NoCache adds the no-cache directive
+func (cc *CacheControl) NoCache() *CacheControl {
+	return cc.add("no-cache")
+}
+
+// NoStore adds the no-store directive
+func (cc *CacheControl) NoStore() *CacheControl {
+	return cc.add("no-store")
+}
+
+// NoTransform adds the no-transform directive
+func (cc *CacheControl) NoTransform() *CacheControl {
+	return cc.add("no-transform")
+}
+
+// MustRevalidate adds the must-revalidate directive
+func (cc *CacheControl) MustRevalidate() *CacheControl {
+	return cc.add("must-revalidate")
+}
+
+// Immutable adds the immutable directive
+func (cc *CacheControl) Immutable() *CacheControl {
+	return cc.add("immutable")
+}
+
+// MaxAge adds the max-age directive, truncated to the second
+func (cc *CacheControl) MaxAge(d time.Duration) *CacheControl {
+	return cc.addDuration("max-age", d)
+}
+
+// SMaxAge adds the s-maxage directive, truncated to the second
+func (cc *CacheControl) SMaxAge(d time.Duration) *CacheControl {
+	return cc.addDuration("s-maxage", d)
+}
+
+// StaleWhileRevalidate adds the stale-while-revalidate directive,
+// truncated to the second
+func (cc *CacheControl) StaleWhileRevalidate(d time.Duration) *CacheControl {
+	return cc.addDuration("stale-while-revalidate", d)
+}
+
+// String returns the value of the Cache-Control header
+func (cc *CacheControl) String() string {
+	return strings.Join(cc.directives, ", ")
+}
+
+// Set sets the Cache-Control header in the response of the
+// linked Route, replacing any previous value
+func (cc *CacheControl) Set() {
+	if cc.route == nil {
+		return
+	}
+
+	cc.route.W.Header().Set("Cache-Control", cc.String())
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// SetClientAuth enables the TLS client certificate authentication (mutual TLS)
+// on a secure server: auth tells whether the client certificates are requested
+// and/or required and whether they must be verified (see tls.ClientAuthType), and
+// the PEM files provided contain the certificate authorities used to verify them
+// (if none is provided, the system pool is used). The verified client certificate
+// is available to the handlers with Route.ClientCertificate and can be checked
+// by the RequireClientCert middleware.
+//
+// The authentication happens during the TLS handshake, so it applies to every
+// domain of the server: with tls.VerifyClientCertIfGiven the public domains can
+// still be served to clients without a certificate. It should be set before starting the server
+func (srv *HTTPServer) SetClientAuth(auth tls.ClientAuthType, caPEMPaths ...string) error {
+	if !srv.Secure || srv.Server.TLSConfig == nil {
+		return errors.New("client authentication requires a secure server")
+	}
+
+	var pool *x509.CertPool
+	if len(caPEMPaths) > 0 {
+		pool = x509.NewCertPool()
+		for _, path := range caPEMPaths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error reading client CA: %w", err)
+			}
+
+			if !pool.AppendCertsFromPEM(data) {
+				return fmt.Errorf("no valid certificate found in client CA %s", path)
+			}
+		}
+	}
+
+	srv.Server.TLSConfig.ClientAuth = auth
+	srv.Server.TLSConfig.ClientCAs = pool
+	return nil
+}
+
+// clientCertificate returns the verified client certificate
+// of the request, if any
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// ClientCertificate returns the certificate provided by the client during the TLS
+// handshake (see HTTPServer.SetClientAuth), only if it was verified against the
+// client certificate authorities, otherwise nil
+func (route *Route) ClientCertificate() *x509.Certificate {
+	return clientCertificate(route.R)
+}
+
+// RequireClientCert returns a middleware that rejects with 403 Forbidden the requests
+// without a verified client certificate (see HTTPServer.SetClientAuth) or with a
+// certificate not accepted by the validate function, that can be used to authorize the
+// clients by their subject. If validate is nil, every verified certificate is accepted
+func RequireClientCert(validate func(cert *x509.Certificate) bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := clientCertificate(r)
+			if cert == nil || (validate != nil && !validate(cert)) {
+				http.Error(w, "Client certificate required", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CompressionMinSize is the minimum size, in bytes, of the responses compressed
+// when the Website has the compression enabled (see Website.Compression). The
+// responses without a known length are always compressed
+var CompressionMinSize int64 = 1024
+
+// COMPRESSION_GZIP is the gzip encoding, the only one currently
+// supported by the response compression (see Website.Compression)
+const COMPRESSION_GZIP = "gzip"
+
+// compressedWriter passes the compressed bytes to the underlying
+// http.ResponseWriter, counting them as the bytes written
+type compressedWriter struct {
+	w *ResponseWriter
+}
+
+func (cw compressedWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.w.Write(p)
+	cw.w.written += int64(n)
+	return n, err
+}
+
+// prepareCompression negotiates the encoding of the response with the client,
+// based on the encodings enabled in the Website (see Website.Compression) and the
+// Accept-Encoding header of the request. If an encoding is chosen, the response
+// body is compressed by the ResponseWriter, if its content type is compressible,
+// and the ranges are disabled, because they would refer to the uncompressed content
+func (route *Route) prepareCompression() {
+	if len(route.Website.Compression) == 0 {
+		return
+	}
+	route.AddVary("Accept-Encoding")
+
+	for _, encoding := range route.Website.Compression {
+		if encoding != COMPRESSION_GZIP || !acceptsEncoding(route.R.Header.Get("Accept-Encoding"), encoding) {
+			continue
+		}
+
+		route.W.encoding = encoding
+		route.R.Header.Del("Range")
+		route.R.Header.Del("If-Range")
+		return
+	}
+}
+
+// acceptsEncoding tells whether the Accept-Encoding header value accepts the
+// encoding, either explicitly or with the "*" wildcard, with a non-zero quality
+func acceptsEncoding(header string, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if strings.EqualFold(name, encoding) {
+			return q > 0
+		}
+		accepted = q > 0
+	}
+
+	return accepted
+}
+
+// isCompressible tells whether the content type is worth compressing: the text
+// types, JSON, JavaScript and XML. Images, videos and archives are excluded, since
+// they are usually already compressed
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/json", mediaType == "application/javascript",
+		mediaType == "application/xml", mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "application/") &&
+		(strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")):
+		return true
+	default:
+		return false
+	}
+}
+
+// startCompression decides, right before the header is sent, whether the response
+// body must be compressed with the negotiated encoding (see Route.prepareCompression):
+// only the successful responses with a compressible content type not already encoded
+// are compressed. If so, the Content-Encoding header is set and the Content-Length
+// is removed, since the compressed length is not known in advance
+func (w *ResponseWriter) startCompression(code int) {
+	if w.encoding == "" || w.compressing || w.hasWrote {
+		return
+	}
+
+	header := w.w.Header()
+	if code != http.StatusOK || header.Get("Content-Encoding") != "" || !isCompressible(header.Get("Content-Type")) {
+		w.encoding = ""
+		return
+	}
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length < CompressionMinSize {
+		w.encoding = ""
+		return
+	}
+
+	w.compressing = true
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+}
+
+// compressedWrite writes the data through the compressor,
+// creating it on the first write with some data
+func (w *ResponseWriter) compressedWrite(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	if w.gz == nil {
+		w.gz = gzip.NewWriter(compressedWriter{w})
+	}
+
+	w.hasWrote = true
+	return w.gz.Write(data)
+}
+
+// closeCompression writes the remaining compressed data, if the
+// response body was compressed
+func (w *ResponseWriter) closeCompression() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	err := w.gz.Close()
+	w.gz = nil
+	return err
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// compressionTestCSS is a compressible asset bigger than CompressionMinSize
+var compressionTestCSS = strings.Repeat("body { color: red; }\n", 200)
+
+// newCompressionTestServer creates a server with the gzip compression enabled,
+// serving the static files of a directory with a big and a small CSS file and
+// an image, which already sets the Vary header like another middleware would do
+func newCompressionTestServer(t *testing.T) *HTTPServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"style.css": compressionTestCSS,
+		"small.css": "a{}",
+		"image.png": "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 2048),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return newTestServer(t, SubdomainConfig{
+		Website: Website{Dir: dir, AllFolders: []string{""}, Compression: []string{"br", COMPRESSION_GZIP}},
+		ServeF: func(route *Route) {
+			route.W.Header().Set("Vary", "Origin")
+			route.StaticServe(true)
+		},
+	})
+}
+
+// checkCompressedResponse checks the encoding and the body of a response for
+// the given Accept-Encoding header
+func checkCompressedResponse(t *testing.T, res *http.Response, uri string, acceptEncoding string) {
+	t.Helper()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vary := res.Header.Values("Vary"); len(vary) != 1 || vary[0] != "Origin, Accept-Encoding" {
+		t.Errorf("%s %q: got Vary %q", uri, acceptEncoding, vary)
+	}
+
+	wantGzip := uri == "/style.css" && acceptsEncoding(acceptEncoding, COMPRESSION_GZIP)
+	if !wantGzip {
+		if enc := res.Header.Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s %q: got Content-Encoding %q, want none", uri, acceptEncoding, enc)
+		}
+		return
+	}
+
+	if enc := res.Header.Get("Content-Encoding"); enc != COMPRESSION_GZIP {
+		t.Fatalf("%s %q: got Content-Encoding %q, want gzip", uri, acceptEncoding, enc)
+	}
+	if cl := res.Header.Get("Content-Length"); cl != "" && cl != strconv.Itoa(len(body)) {
+		t.Errorf("%s %q: got Content-Length %s, want the compressed length %d", uri, acceptEncoding, cl, len(body))
+	}
+
+	zr, err := gzip.NewReader(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("%s %q: invalid gzip body: %v", uri, acceptEncoding, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || string(data) != compressionTestCSS {
+		t.Errorf("%s %q: the decompressed body does not match (%v)", uri, acceptEncoding, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	srv := newCompressionTestServer(t)
+
+	for _, uri := range []string{"/style.css", "/small.css", "/image.png"} {
+		for _, acceptEncoding := range []string{"gzip", "br, gzip;q=0.5", "", "br", "gzip;q=0", "*"} {
+			req := httptest.NewRequest(http.MethodGet, uri, nil)
+			if acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+
+			checkCompressedResponse(t, doTestRequest(srv, req).Result(), uri, acceptEncoding)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.8", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"br", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, COMPRESSION_GZIP); got != tt.want {
+			t.Errorf("acceptsEncoding(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -3,9 +3,14 @@ package server
 import (
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nixpare/logger"
 )
 
 // Domain rapresents a website domain with all its
@@ -16,12 +21,15 @@ import (
 //   - an error template, that will be used in case your logic
 //     will throw any error, so you will have a constant look
 type Domain struct {
-	Name         string
-	subdomains   map[string]*Subdomain
-	srv          *HTTPServer
-	headers      http.Header
-	errTemplate  *template.Template
-	beforeServeF BeforeServeFunction
+	Name          string
+	subdomains    map[string]*Subdomain
+	srv           *HTTPServer
+	headers       http.Header
+	errTemplate   atomic.Pointer[template.Template]
+	beforeServeF  BeforeServeFunction
+	canonicalHost CanonicalHostMode
+	requests      requestCounters
+	errTmplPath   string
 }
 
 // Subdomain rapresents a particular subdomain in a domain with all the
@@ -35,15 +43,35 @@ type Domain struct {
 //   - an initializer function, called when the server is starting up
 //   - a cleanup function, called when the server is shutting down
 type Subdomain struct {
-	Name        string
-	website     *Website
-	serveF      ServeFunction
-	initF       InitCloseFunction
-	closeF      InitCloseFunction
-	headers     http.Header
-	errTemplate *template.Template
-	offline     bool
-	state       *LifeCycle
+	Name           string
+	website        atomic.Pointer[Website]
+	serveF         ServeFunction
+	initF          InitCloseFunction
+	closeF         InitCloseFunction
+	headers        http.Header
+	errTemplate    atomic.Pointer[template.Template]
+	beforeServeF   BeforeServeFunction
+	offline        bool
+	state          *LifeCycle
+	latency        latencyTracker
+	templates      atomic.Pointer[template.Template]
+	noErrorCapture bool
+	timeout        time.Duration
+	staticResps    map[string]*staticResponse
+	domain         *Domain
+	mux            *subdomainMux
+	slashNorm      SlashNormalization
+	allowedMethods []string
+	admission      *admissionQueue
+	locales        []string
+	localeCookie   string
+	initPolicy     InitFailurePolicy
+	initFailed     atomic.Bool
+	methodOverride []string
+	wsActive       atomic.Int64
+	errTmplPath    string
+	tmplFS         fs.FS
+	tmplPatterns   []string
 }
 
 // SubdomainConfig is used to create a Subdomain. The Website should not be
@@ -117,20 +145,7 @@ func (d *Domain) RegisterSubdomain(subdomain string, c SubdomainConfig) *Subdoma
 		c.ServeF = func(route *Route) { route.StaticServe(true) }
 	}
 
-	if !isAbs(c.Website.Dir) {
-		if c.Website.Dir == "" {
-			c.Website.Dir = d.srv.ServerPath + "/public"
-		} else {
-			c.Website.Dir = d.srv.ServerPath + "/" + c.Website.Dir
-		}
-	} else {
-		if strings.HasPrefix(c.Website.Dir, "~") {
-			home, err := os.UserHomeDir()
-			if err == nil {
-				c.Website.Dir = strings.Replace(c.Website.Dir, "~", home, 1)
-			}
-		}
-	}
+	c.Website.Dir = resolveWebsiteDir(d.srv.ServerPath, c.Website.Dir)
 
 	ws := &Website{
 		Name:                   c.Website.Name,
@@ -142,22 +157,35 @@ func (d *Domain) RegisterSubdomain(subdomain string, c SubdomainConfig) *Subdoma
 		PageHeaders:            c.Website.PageHeaders,
 		XFiles:                 make(map[string]string),
 		AvoidMetricsAndLogging: c.Website.AvoidMetricsAndLogging,
+		NoSymlinkEscape:        c.Website.NoSymlinkEscape,
+		LogHeaders:             c.Website.LogHeaders,
+		DefaultFavicon:         c.Website.DefaultFavicon,
+		RobotsTxt:              c.Website.RobotsTxt,
+		NoLogBotPages:          c.Website.NoLogBotPages,
+		PushAssets:             c.Website.PushAssets,
+		Compression:            c.Website.Compression,
 	}
 
 	for key, value := range c.Website.XFiles {
 		if value == "" {
-			ws.XFiles[key] = key
+			value = key
 		}
 
+		if err := ws.checkXFile(value); err != nil {
+			d.srv.Logger.Printf(logger.LOG_LEVEL_ERROR, "XFile \"%s\" of website \"%s\" ignored: %v", key, ws.Name, err)
+			continue
+		}
 		ws.XFiles[key] = value
 	}
 
 	sd := &Subdomain{
-		Name: subdomain, website: ws,
+		Name:   subdomain,
 		serveF: c.ServeF, initF: c.InitF, closeF: c.CloseF,
 		headers: make(http.Header),
-		state: NewLifeCycleState(),
+		state:   NewLifeCycleState(),
+		domain:  d,
 	}
+	sd.website.Store(ws)
 	d.subdomains[subdomain] = sd
 
 	if d.srv.state.GetState() == LCS_STARTED {
@@ -167,6 +195,43 @@ func (d *Domain) RegisterSubdomain(subdomain string, c SubdomainConfig) *Subdoma
 	return sd
 }
 
+// resolveWebsiteDir returns the effective directory of a website: first the
+// environment variables written like $VAR or ${VAR} are expanded (see os.ExpandEnv),
+// then the "~" prefix is replaced with the user home directory and if the result
+// is not an absolute path, it's relative to the server path (an empty path
+// corresponds to the "public" folder inside the server path)
+func resolveWebsiteDir(serverPath string, dir string) string {
+	dir = os.ExpandEnv(dir)
+
+	if !isAbs(dir) {
+		if dir == "" {
+			return serverPath + "/public"
+		}
+		return serverPath + "/" + dir
+	}
+
+	if strings.HasPrefix(dir, "~") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			dir = strings.Replace(dir, "~", home, 1)
+		}
+	}
+
+	return dir
+}
+
+// SetDir changes the directory of the subdomain website at runtime. The path is
+// resolved like the Website.Dir when registering the subdomain: the environment
+// variables (like ${CONTENT_ROOT}) are expanded, the "~" prefix is replaced with
+// the home directory and relative paths are relative to the server path. The
+// variables are expanded only when the subdomain is registered or when this
+// method is called. The connections already being served keep the previous directory
+func (sd *Subdomain) SetDir(path string) {
+	ws := *sd.website.Load()
+	ws.Dir = resolveWebsiteDir(sd.domain.srv.ServerPath, path)
+	sd.website.Store(&ws)
+}
+
 // RegisterDefaultSubdomain registers a subdomain that is called if no other one
 // matches perfectly the incoming connection for the same domain
 func (d *Domain) RegisterDefaultSubdomain(c SubdomainConfig) *Subdomain {
@@ -195,6 +260,29 @@ func (d *Domain) SetBeforeServeF(f BeforeServeFunction) {
 	d.beforeServeF = f
 }
 
+// CanonicalHostMode tells how the "www." prefix of the host must be handled
+// by a Domain. See the constants for the possible values
+type CanonicalHostMode int
+
+const (
+	// CANONICAL_HOST_NONE does not redirect any connection (default)
+	CANONICAL_HOST_NONE CanonicalHostMode = iota
+	// CANONICAL_HOST_STRIP_WWW redirects every connection with the "www." prefix
+	// to the same host without it (www.mydomain.com -> mydomain.com)
+	CANONICAL_HOST_STRIP_WWW
+	// CANONICAL_HOST_FORCE_WWW redirects every connection without the "www." prefix
+	// to the same host with it (mydomain.com -> www.mydomain.com)
+	CANONICAL_HOST_FORCE_WWW
+)
+
+// SetCanonicalHost sets how the "www." prefix of the host must be handled: every
+// external connection (see Route.IsInternalConn) not matching the canonical host
+// is permanently redirected (301) to it, before the before serve functions are executed.
+// Connections to IP addresses and localhost are never redirected
+func (d *Domain) SetCanonicalHost(mode CanonicalHostMode) {
+	d.canonicalHost = mode
+}
+
 // SetHeaders adds headers to the collection of headers used in every connection.
 // This is a faster way to set multiple headers at the same time, instead of using
 // domain.SetHeader. The headers must be provided in this way:
@@ -267,6 +355,142 @@ func (sd *Subdomain) SetHeaders(headers [][2]string) {
 	}
 }
 
+// staticResponse is a precomputed response registered in a subdomain
+type staticResponse struct {
+	status      int
+	contentType string
+	body        []byte
+	etag        string
+}
+
+// ServeStaticResponse registers a precomputed response for the given request path
+// (matched exactly): every connection directed to that path is served directly with
+// the provided status code, content type and body, without calling the serve function.
+// An ETag is generated from the body, so conditional requests are handled automatically.
+// This can be used for health checks, maintenance pages or fixed JSON
+func (sd *Subdomain) ServeStaticResponse(path string, status int, contentType string, body []byte) {
+	if sd.staticResps == nil {
+		sd.staticResps = make(map[string]*staticResponse)
+	}
+
+	sd.staticResps[path] = &staticResponse{
+		status:      status,
+		contentType: contentType,
+		body:        body,
+		etag:        fmt.Sprintf("\"%s\"", GenerateHashString(body)[:32]),
+	}
+}
+
+// ServeRobotsTxt registers the content of the /robots.txt file as a static
+// response, see Subdomain.ServeStaticResponse
+func (sd *Subdomain) ServeRobotsTxt(content string) {
+	sd.ServeStaticResponse("/robots.txt", http.StatusOK, "text/plain; charset=utf-8", []byte(content))
+}
+
+// ServeFavicon registers the content of the /favicon.ico file as a static
+// response, see Subdomain.ServeStaticResponse. If contentType is empty, it's
+// detected from the data
+func (sd *Subdomain) ServeFavicon(data []byte, contentType string) {
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	sd.ServeStaticResponse("/favicon.ico", http.StatusOK, contentType, data)
+}
+
+// SetTimeout sets a deadline on the request context of every connection directed
+// to this subdomain. This timeout takes precedence over the server one (see
+// HTTPServer.SetHandlerTimeout), a negative value disables the timeout for the
+// subdomain even if the server one is set and zero means to use the server one.
+//
+// The timeout is cooperative: the serve function should check the request context
+// (see Route.Context) and stop its work when it's done. If the serve function returns
+// after the deadline without writing anything, a 503 Service Unavailable error is
+// reported. A single serve function can opt-out with Route.DisableTimeout
+func (sd *Subdomain) SetTimeout(d time.Duration) {
+	sd.timeout = d
+}
+
+// SetErrorCapture enables or disables the error capture for every connection
+// directed to this subdomain, see HTTPServer.SetErrorCapture. If the error capture
+// is disabled for the server, it can't be enabled for the subdomain
+func (sd *Subdomain) SetErrorCapture(v bool) {
+	sd.noErrorCapture = !v
+}
+
+// SetAllowedMethods sets the only HTTP methods accepted by the subdomain: every
+// request with a different method is rejected with 405 Method Not Allowed and
+// the Allow header, before the serve function is called. If GET is allowed, HEAD
+// is allowed (and listed in the Allow header) too. Calling it with no methods
+// allows every method (default)
+func (sd *Subdomain) SetAllowedMethods(methods ...string) {
+	sd.allowedMethods = nil
+	var get, head bool
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		get = get || m == http.MethodGet
+		head = head || m == http.MethodHead
+		sd.allowedMethods = append(sd.allowedMethods, m)
+	}
+
+	if get && !head {
+		sd.allowedMethods = append(sd.allowedMethods, http.MethodHead)
+	}
+}
+
+// isMethodAllowed tells whether the subdomain accepts the
+// method, see Subdomain.SetAllowedMethods
+func (sd *Subdomain) isMethodAllowed(method string) bool {
+	if len(sd.allowedMethods) == 0 {
+		return true
+	}
+
+	for _, m := range sd.allowedMethods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetBeforeServeF sets a function that will be executed before every connection
+// directed to this subdomain. It's called after the domain one (see Domain.SetBeforeServeF),
+// only if the latter did not already handle the connection, and when the headers and the
+// error template are already set. If this function returns true, the serve function of
+// the subdomain will not be executed
+func (sd *Subdomain) SetBeforeServeF(f BeforeServeFunction) {
+	sd.beforeServeF = f
+}
+
+// SetHandlerChain replaces the serve function of the subdomain with the chain of
+// the given serve functions (see HandlerChain)
+func (sd *Subdomain) SetHandlerChain(handlers ...ServeFunction) {
+	sd.serveF = HandlerChain(handlers...)
+}
+
+// HandlerChain returns a serve function that tries the given serve functions
+// in order until one of them handles the connection. A serve function is considered
+// to have handled the connection if it has written the response status code (so
+// also when calling Route.Error or when writing any data); if instead it returns
+// without writing anything, the next one is tried. If no serve function handles
+// the connection, a 404 Not Found error is reported.
+//
+// Bear in mind that the headers set by a serve function that did not handle the
+// connection are kept for the next ones
+func HandlerChain(handlers ...ServeFunction) ServeFunction {
+	return func(route *Route) {
+		for _, h := range handlers {
+			h(route)
+			if route.W.code != 0 || route.W.hasWrote {
+				return
+			}
+		}
+
+		route.Error(http.StatusNotFound, "Not found", "No handler in the chain handled the connection")
+	}
+}
+
 // RemoveHeader removes a header with the given name
 func (sd *Subdomain) RemoveHeader(name string) {
 	sd.headers.Del(name)
@@ -277,16 +501,24 @@ func (sd *Subdomain) Header() http.Header {
 	return sd.headers
 }
 
-func (sd *Subdomain) start(srv *HTTPServer, d *Domain) {
+// start calls the initialization function of the subdomain, applying the
+// init failure policy if it fails (see Subdomain.SetInitFailurePolicy). The
+// error is returned only if the policy requires the server startup to fail
+func (sd *Subdomain) start(srv *HTTPServer, d *Domain) error {
 	if sd.state.AlreadyStarted() {
-		return
+		return nil
 	}
 	sd.state.SetState(LCS_STARTING)
 
-	if sd.initF != nil {
-		sd.initF(srv, d, sd, sd.website)
+	if err := sd.runInitF(srv, d); err != nil {
+		if sd.initPolicy == INIT_FAILURE_FAIL_SERVER {
+			sd.state.SetState(LCS_STOPPED)
+			return err
+		}
+		sd.handleInitFailure(srv, d)
 	}
 	sd.state.SetState(LCS_STARTED)
+	return nil
 }
 
 func (sd *Subdomain) stop(srv *HTTPServer, d *Domain) {
@@ -296,7 +528,7 @@ func (sd *Subdomain) stop(srv *HTTPServer, d *Domain) {
 	sd.state.SetState(LCS_STOPPING)
 
 	if sd.closeF != nil {
-		sd.closeF(srv, d, sd, sd.website)
+		sd.closeF(srv, d, sd, sd.website.Load())
 	}
 	sd.state.SetState(LCS_STOPPED)
 }
@@ -311,6 +543,22 @@ func (sd *Subdomain) Disable() {
 	sd.offline = true
 }
 
+// LoadTemplatesFS parses the HTML templates matching the patterns inside the
+// file system (for example an embed.FS) and saves them in the subdomain, so that
+// they can be executed with Route.Render. The patterns follow the rules of
+// fs.Glob and every template is named after its file base name. Calling this
+// method again replaces the previously loaded templates
+func (sd *Subdomain) LoadTemplatesFS(fsys fs.FS, patterns ...string) error {
+	t, err := template.ParseFS(fsys, patterns...)
+	if err != nil {
+		return fmt.Errorf("error parsing templates: %w", err)
+	}
+
+	sd.templates.Store(t)
+	sd.tmplFS, sd.tmplPatterns = fsys, patterns
+	return nil
+}
+
 // SetErrorTemplate sets the error template used server-wise. It's
 // required an HTML that contains two specific fields, a .Code one and
 // a .Message one, for example like so:
@@ -323,7 +571,8 @@ func (srv *HTTPServer) SetErrorTemplate(content string) error {
 		return fmt.Errorf("error parsing template file: %w", err)
 	}
 
-	srv.errTemplate = t
+	srv.errTemplate.Store(t)
+	srv.errTmplPath = ""
 	return nil
 }
 
@@ -339,7 +588,8 @@ func (d *Domain) SetErrorTemplate(content string) error {
 		return fmt.Errorf("error parsing template file: %w", err)
 	}
 
-	d.errTemplate = t
+	d.errTemplate.Store(t)
+	d.errTmplPath = ""
 	return nil
 }
 
@@ -355,6 +605,7 @@ func (sd *Subdomain) SetErrorTemplate(content string) error {
 		return fmt.Errorf("error parsing template file: %w", err)
 	}
 
-	sd.errTemplate = t
+	sd.errTemplate.Store(t)
+	sd.errTmplPath = ""
 	return nil
 }
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+
+	"github.com/nixpare/logger"
+)
+
+// loadErrorTemplateFile reads and parses the error template file, checking that
+// it actually uses the .Code and .Message fields
+func loadErrorTemplateFile(path string) (*template.Template, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template file: %w", err)
+	}
+
+	t, err := template.New("error.html").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template file: %w", err)
+	}
+
+	const code, message = 599, "error-template-message-check"
+	data := struct {
+		Code    int
+		Message string
+	}{
+		Code:    code,
+		Message: message,
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("error executing template file: %w", err)
+	}
+	if !strings.Contains(buf.String(), fmt.Sprint(code)) {
+		return nil, errors.New("template file does not contain the .Code field")
+	}
+	if !strings.Contains(buf.String(), message) {
+		return nil, errors.New("template file does not contain the .Message field")
+	}
+
+	return t, nil
+}
+
+// watchErrorTemplateFile registers a task in the TaskManager of the Router that
+// every 10 seconds checks the file for changes and, if so, parses it again and
+// passes the new template to the set function. If the new template is not valid,
+// the error is logged and the previous template is kept
+func watchErrorTemplateFile(router *Router, name string, path string, set func(t *template.Template)) error {
+	if router == nil {
+		return errors.New("the server was not created through a router")
+	}
+
+	last, err := takeDirSnapshot(path)
+	if err != nil {
+		return fmt.Errorf("error reading watched file: %w", err)
+	}
+
+	return router.TaskMgr.NewTask(name, func() (_, execF, _ TaskFunc) {
+		execF = func(tm *TaskManager, t *Task) error {
+			snap, err := takeDirSnapshot(path)
+			if err != nil {
+				return fmt.Errorf("error reading watched file: %w", err)
+			}
+			if snap == last {
+				return nil
+			}
+			last = snap
+
+			errTemplate, err := loadErrorTemplateFile(path)
+			if err != nil {
+				return err
+			}
+			set(errTemplate)
+
+			tm.Logger.Printf(logger.LOG_LEVEL_INFO, "Error template %s reloaded", path)
+			return nil
+		}
+		return
+	}, TASK_TIMER_10_SECONDS)
+}
+
+// errorTemplatePath completes the path of an error template file
+// with the server path, if not absolute
+func (srv *HTTPServer) errorTemplatePath(path string) string {
+	if !isAbs(path) {
+		path = srv.ServerPath + "/" + path
+	}
+
+	return path
+}
+
+// SetErrorTemplateFromFile reads and parses the error template file, like
+// HTTPServer.SetErrorTemplate, but also checks that it contains both the .Code
+// and the .Message fields. If the path is not absolute, it's relative to the server
+// path. If watch is true, the file is checked periodically for changes and
+// reloaded automatically (see Subdomain.WatchAndReload): this requires
+// the server to have been created through a Router
+func (srv *HTTPServer) SetErrorTemplateFromFile(path string, watch bool) error {
+	path = srv.errorTemplatePath(path)
+
+	t, err := loadErrorTemplateFile(path)
+	if err != nil {
+		return err
+	}
+	srv.errTemplate.Store(t)
+	srv.errTmplPath = path
+
+	if !watch {
+		return nil
+	}
+
+	return watchErrorTemplateFile(
+		srv.Router, fmt.Sprintf("error template :%d", srv.port), path,
+		func(t *template.Template) { srv.errTemplate.Store(t) },
+	)
+}
+
+// SetErrorTemplateFromFile reads and parses the error template file for
+// the domain, see HTTPServer.SetErrorTemplateFromFile
+func (d *Domain) SetErrorTemplateFromFile(path string, watch bool) error {
+	path = d.srv.errorTemplatePath(path)
+
+	t, err := loadErrorTemplateFile(path)
+	if err != nil {
+		return err
+	}
+	d.errTemplate.Store(t)
+	d.errTmplPath = path
+
+	if !watch {
+		return nil
+	}
+
+	return watchErrorTemplateFile(
+		d.srv.Router, fmt.Sprintf("error template %s:%d", d.Name, d.srv.port), path,
+		func(t *template.Template) { d.errTemplate.Store(t) },
+	)
+}
+
+// SetErrorTemplateFromFile reads and parses the error template file for
+// the subdomain, see HTTPServer.SetErrorTemplateFromFile
+func (sd *Subdomain) SetErrorTemplateFromFile(path string, watch bool) error {
+	if sd.domain == nil {
+		return errors.New("subdomain not registered in a domain")
+	}
+	srv := sd.domain.srv
+	path = srv.errorTemplatePath(path)
+
+	t, err := loadErrorTemplateFile(path)
+	if err != nil {
+		return err
+	}
+	sd.errTemplate.Store(t)
+	sd.errTmplPath = path
+
+	if !watch {
+		return nil
+	}
+
+	return watchErrorTemplateFile(
+		srv.Router, fmt.Sprintf("error template %s%s:%d", sd.Name, sd.domain.Name, srv.port), path,
+		func(t *template.Template) { sd.errTemplate.Store(t) },
+	)
+}
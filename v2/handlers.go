@@ -1,10 +1,14 @@
 package server
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +25,8 @@ type Website struct {
 	// while creating the Website, this is set to the server path + /public
 	// folder of the domain in which is registered, otherwise if it's a
 	// relative path, it's considered relative to the server path.
+	// Environment variables like ${VAR} are expanded on registration
+	// (see Subdomain.SetDir to change it at runtime).
 	// This is also used by the function Route.ServeStatic to
 	// automatically serve any content (see AllFolders attribute)
 	Dir string
@@ -62,11 +68,37 @@ type Website struct {
 	// For example: if the XFiles attribute is set to
 	//   XFiles: map[string]string{ "assets/css/index.css": "assets/css/X_INDEX.css" }
 	// and a request comes with a URI of https://<my_domain>/assets/css/index.css, the server will
-	// use the file Website.Dir + / + assets/css/X_INDEX.css to create the XFile and then serve it
+	// use the file Website.Dir + / + assets/css/X_INDEX.css to create the XFile and then serve it.
+	// The entries pointing to a directory are logged and ignored on registration, while a
+	// missing file is reported as 404 Not Found when requested
 	XFiles map[string]string
 	// AvoidMetricsAndLogging disables any type of log for every connection and error regarding
 	// this website (if not explicitly done by the logic calling Route.Log)
 	AvoidMetricsAndLogging bool
+	// NoSymlinkEscape, if set, prevents Route.ServeFile from serving files inside the Website.Dir
+	// that are (or are inside) symbolic links pointing outside of the Website.Dir
+	NoSymlinkEscape bool
+	// LogHeaders is the list of request headers (like Referer or User-Agent) that will be
+	// included in the connection logs. The headers in LogHeadersDenylist are never logged
+	LogHeaders []string
+	// DefaultFavicon, if set, is served by Route.StaticServe for the /favicon.ico requests
+	DefaultFavicon []byte
+	// RobotsTxt, if set, is served by Route.StaticServe for the /robots.txt requests
+	RobotsTxt string
+	// NoLogBotPages avoids logging the 404 Not Found errors for the /favicon.ico
+	// and /robots.txt requests, usually made by bots and browsers
+	NoLogBotPages bool
+	// PushAssets maps the requestURIs to the assets that are pushed to the
+	// client with HTTP/2 server push (see Route.Push) before serving the page, like so:
+	//   PushAssets: map[string][]string{ "/": {"/assets/css/index.css", "/assets/js/index.js"} }
+	// Server push is ignored by most browsers, so prefer 103 Early Hints when possible
+	PushAssets map[string][]string
+	// Compression lists the encodings used to compress the text responses (like HTML,
+	// CSS, JavaScript and JSON) served by Route.ServeFile and the XFiles, in order of
+	// preference, if accepted by the client. Only COMPRESSION_GZIP is currently supported,
+	// the other encodings are ignored. The responses smaller than CompressionMinSize
+	// are not compressed and the bytes logged are the compressed ones
+	Compression []string
 }
 
 // ServeFunction defines the type of the function that is executed every time a connection is
@@ -104,6 +136,14 @@ type ServeFunction func(route *Route)
 */
 type BeforeServeFunction func(route *Route) bool
 
+// ExpectContinueFunction defines the type of the function executed when a request
+// has the "Expect: 100-continue" header, before the client sends the request body
+// and before the serve function. The function can inspect the request headers
+// (like the authorization or the content length) and returns whether the body should
+// be accepted: if not, the returned status code (like 413 Request Entity Too Large or
+// 417 Expectation Failed) is sent to the client without reading the body
+type ExpectContinueFunction func(route *Route) (statusCode int, accept bool)
+
 // InitCloseFunction defines the type of the function executed when a new subdomain is created or removed, usually
 // when the relative server is started or stopped. Bear in mind that if you use the same function on
 // multiple subdomain, maybe belonging to different servers, you could have to manually check that this function is done
@@ -117,10 +157,29 @@ type ResponseWriter struct {
 	disableErrorCapture bool
 	caputedError        []byte
 	hasWrote            bool
+	headerSent          bool
 	code                int
 	written             int64
+	timings             []string
+	noRanges            bool
+	stripHeaders        []string
+	headerAllowlist     map[string]bool
+	captureOverflow     bool
+	declaredLength      int64
+	lengthDeclared      bool
+	hijacked            *countingConn
+	encoding            string
+	compressing         bool
+	gz                  *gzip.Writer
 }
 
+// ErrorCaptureThreshold is the maximum number of bytes of an error response body
+// (status code >= 400) buffered by the ResponseWriter, so that it can be replaced by
+// the error template. When exceeded, the buffered bytes are sent and the rest of the
+// body is streamed to the client as it is, without being replaced. This keeps the memory
+// usage low for large error responses. A value <= 0 means no limit
+var ErrorCaptureThreshold = 32 * 1024
+
 // Header is the equivalent of the http.ResponseWriter method
 func (w *ResponseWriter) Header() http.Header {
 	return w.w.Header()
@@ -129,8 +188,24 @@ func (w *ResponseWriter) Header() http.Header {
 // Write is the equivalent of the http.ResponseWriter method
 func (w *ResponseWriter) Write(data []byte) (int, error) {
 	if w.code >= 400 && !w.disableErrorCapture {
-		w.caputedError = append(w.caputedError, data...)
-		return len(data), nil
+		if ErrorCaptureThreshold <= 0 || len(w.caputedError)+len(data) <= ErrorCaptureThreshold {
+			w.caputedError = append(w.caputedError, data...)
+			return len(data), nil
+		}
+
+		if err := w.flushCapturedError(); err != nil {
+			return 0, err
+		}
+	}
+
+	w.sendHeader()
+	if !w.headerSent {
+		w.startCompression(http.StatusOK)
+	}
+
+	w.prepareHeader()
+	if w.compressing {
+		return w.compressedWrite(data)
 	}
 
 	n, err := w.w.Write(data)
@@ -142,20 +217,109 @@ func (w *ResponseWriter) Write(data []byte) (int, error) {
 	return n, err
 }
 
+// flushCapturedError stops the error capture, sending the
+// error body buffered so far, see ErrorCaptureThreshold
+func (w *ResponseWriter) flushCapturedError() error {
+	w.disableErrorCapture = true
+	w.captureOverflow = true
+
+	captured := w.caputedError
+	w.caputedError = nil
+	if len(captured) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(captured)
+	return err
+}
+
 // WriteHeader is the equivalent of the http.ResponseWriter method
-// but handles multiple calls, using only the first one used
+// but handles multiple calls, using only the first one used.
+// Error status codes (>= 400) are not sent immediately, but only
+// when the error is served (see Route.Error), so the headers can
+// still be modified until then
 func (w *ResponseWriter) WriteHeader(statusCode int) {
 	if w.code != 0 {
 		return
 	}
 
 	w.code = statusCode
-	w.w.WriteHeader(statusCode)
+	if statusCode >= 400 && !w.disableErrorCapture {
+		return
+	}
+
+	w.sendHeader()
+}
+
+// Flush implements the http.Flusher interface, sending any buffered
+// data to the client, if the underlying http.ResponseWriter supports it
+func (w *ResponseWriter) Flush() {
+	w.sendHeader()
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sendHeader writes the status code to the underlying http.ResponseWriter,
+// if it was set and not already sent
+func (w *ResponseWriter) sendHeader() {
+	if w.headerSent || w.code == 0 {
+		return
+	}
+
+	w.headerSent = true
+	w.startCompression(w.code)
+	w.prepareHeader()
+	w.w.WriteHeader(w.code)
+}
+
+// prepareHeader sets the headers that depend on the whole handling of the
+// request, right before they are sent: the Server-Timing header with the timings
+// recorded so far, if any (see Route.Timing), and the Accept-Ranges header if
+// the ranges were disabled (see Route.DisableRanges). Then the headers are
+// filtered, see Route.StripHeaders and Route.HeaderAllowlist
+func (w *ResponseWriter) prepareHeader() {
+	if w.hasWrote {
+		return
+	}
+
+	header := w.w.Header()
+	if len(w.timings) != 0 {
+		header.Set("Server-Timing", strings.Join(w.timings, ", "))
+	}
+	if w.noRanges {
+		header.Set("Accept-Ranges", "none")
+	}
+
+	for _, name := range w.stripHeaders {
+		header.Del(name)
+	}
+	if w.headerAllowlist != nil {
+		for name := range header {
+			if !w.headerAllowlist[name] && !isFramingHeader(name) {
+				delete(header, name)
+			}
+		}
+	}
 }
 
-// metrics is a collection of parameters to log taken from an HTTP
+// isFramingHeader tells whether the header is needed to correctly
+// read the response body, so it's never removed by the header allowlist
+func isFramingHeader(name string) bool {
+	switch name {
+	case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Trailer":
+		return true
+	default:
+		return false
+	}
+}
+
+// Metrics is a collection of parameters to log taken from an HTTP
 // connection
-type metrics struct {
+type Metrics struct {
 	Code     int
 	Duration time.Duration
 	Written  int64
@@ -224,6 +388,25 @@ type Route struct {
 	logErrMessage string
 	// errTemplate contains the error template: it could be inherited by the server, domain or subdomain
 	errTemplate *template.Template
+	// bodyLimited tells whether the request body was already wrapped with the MaxBodySize limit
+	bodyLimited bool
+	// requestID is the ID of the request, see Route.RequestID
+	requestID string
+	// annotations contains the context added with Route.Annotate
+	annotations []string
+	// noErrorCapture tells whether the error capture was disabled by the server or by the subdomain
+	noErrorCapture bool
+	// parentCtx is the request context before the timeout was applied
+	parentCtx context.Context
+	// pathParams contains the path parameters matched by the subdomain path router
+	pathParams map[string]string
+	// onFinish contains the callbacks registered with Route.OnFinish
+	onFinish []func(m Metrics)
+	// locale is the locale of the response, see Route.Locale
+	locale string
+	// rewriteRedirect is the URI where the client must be redirected by a rewrite rule
+	rewriteRedirect     string
+	rewriteRedirectCode int
 }
 
 // handler is the HTTP handler for the server. At creation, it's set wheather
@@ -236,7 +419,14 @@ type handler struct {
 
 // setHandler sets the http.Handler to the http.Server
 func (srv *HTTPServer) setHandler() {
-	srv.Server.Handler = handler{srv.Secure, srv}
+	var h http.Handler = handler{srv.Secure, srv}
+
+	h = applyMiddlewares(h, srv.middlewares)
+	if srv.Router != nil {
+		h = applyMiddlewares(h, srv.Router.middlewares)
+	}
+
+	srv.Server.Handler = h
 }
 
 // ServeHTTP is the first function called by the http.Server at any connection
@@ -267,6 +457,12 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	route.prep()
 	route.Logger = route.Logger.Clone(nil, route.DomainName, route.SubdomainName)
 
+	if !h.srv.waitResume() && route.err == err_no_err {
+		route.err = err_server_paused
+	}
+
+	defer route.runFinishCallbacks()
+	defer route.countRequest()
 	defer func() {
 		if p := recover(); p != nil {
 			route.logErrMessage = fmt.Sprintf("%v\nstack: %s", p, logger.Stack())
@@ -274,12 +470,23 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 	route.serve()
+	if err := route.W.closeCompression(); err != nil {
+		route.Logger.Printf(logger.LOG_LEVEL_WARNING, "Error compressing %s: %v", route.logRequestURI, err)
+	}
+	route.checkContentLength()
 
 	if route.Website.AvoidMetricsAndLogging {
 		return
 	}
 	metrics := route.getMetrics()
 
+	route.prepLogHeaders()
+	route.prepLogTLSInfo()
+
+	if h.srv.trackLatency && route.err == err_no_err {
+		route.Subdomain.latency.add(metrics.Duration)
+	}
+
 	switch {
 	case metrics.Code < 400:
 		route.avoidNoLogPages()
@@ -289,6 +496,10 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		route.logHTTPInfo(metrics)
 	case metrics.Code >= 400 && metrics.Code < 500:
+		if metrics.Code == http.StatusNotFound && route.Website.NoLogBotPages && isBotPage(route.RequestURI) {
+			return
+		}
+
 		route.logHTTPWarning(metrics)
 	default:
 		route.logHTTPError(metrics)
@@ -299,9 +510,16 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // is prepared. It will first set every possible default header
 // of the domain and/or subdomain, then it will execute the before
 // each function, then will handle the errors and finally the serve
-// function of the subdomain
+// function of the subdomain. The before serve functions are executed
+// in order: first the domain one and then the subdomain one
 func (route *Route) serve() {
 	route.W.Header().Set("server", "NixServer")
+
+	if route.Srv.noErrorCapture || (route.Subdomain != nil && route.Subdomain.noErrorCapture) {
+		route.noErrorCapture = true
+		route.W.disableErrorCapture = true
+	}
+
 	defer func() {
 		if route.W.code >= 400 {
 			route.serveError()
@@ -326,18 +544,30 @@ func (route *Route) serve() {
 		}
 	}
 
-	route.errTemplate = route.Srv.errTemplate
+	route.errTemplate = route.Srv.errTemplate.Load()
 	if domain != nil {
-		if domain.errTemplate != nil {
-			route.errTemplate = domain.errTemplate
+		if t := domain.errTemplate.Load(); t != nil {
+			route.errTemplate = t
 		}
 	}
 	if subdomain != nil {
-		if subdomain.errTemplate != nil {
-			route.errTemplate = subdomain.errTemplate
+		if t := subdomain.errTemplate.Load(); t != nil {
+			route.errTemplate = t
 		}
 	}
 
+	if route.redirectRewrite() {
+		return
+	}
+
+	if route.redirectToCanonicalHost() {
+		return
+	}
+
+	if route.err == err_no_err && route.normalizeSlash() {
+		return
+	}
+
 	var doNotContinue bool
 	if route.Domain.beforeServeF != nil {
 		doNotContinue = route.Domain.beforeServeF(route)
@@ -346,8 +576,18 @@ func (route *Route) serve() {
 		return
 	}
 
+	if route.Subdomain != nil && route.Subdomain.beforeServeF != nil {
+		doNotContinue = route.Subdomain.beforeServeF(route)
+	}
+	if doNotContinue {
+		return
+	}
+
 	if route.Subdomain != nil && route.Subdomain.offline {
 		route.err = err_website_offline
+		if route.Subdomain.initPolicy == INIT_FAILURE_ERROR_PAGE && route.Subdomain.InitFailed() {
+			route.err = err_website_init_failed
+		}
 	}
 
 	if !route.Srv.Online {
@@ -364,47 +604,257 @@ func (route *Route) serve() {
 			route.W.Header().Set("Retry-After", t.Format(time.RFC1123))
 			route.Error(http.StatusServiceUnavailable, "Server temporarly offline, retry in "+time.Until(t).Truncate(time.Second).String())
 
+		case err_server_paused:
+			route.W.Header().Set("Retry-After", "1")
+			route.Error(http.StatusServiceUnavailable, "Server temporarly paused")
+
 		case err_website_offline:
 			t := route.Srv.OnlineTime.Add(time.Minute * 30)
 			route.W.Header().Set("Retry-After", t.Format(time.RFC1123))
 			route.Error(http.StatusServiceUnavailable, "Website temporarly offline")
 
+		case err_website_init_failed:
+			route.Error(http.StatusServiceUnavailable, "Website failed to start", "Subdomain initialization failed")
+
 		case err_domain_not_found:
 			if net.ParseIP(route.DomainName) == nil {
+				if route.Srv.unknownHostF != nil {
+					route.Srv.unknownHostF(route)
+					break
+				}
 				route.Error(http.StatusBadRequest, fmt.Sprintf("Domain \"%s\" not served by this server", route.DomainName))
 			} else {
+				if route.Srv.directIPF != nil {
+					route.Srv.directIPF(route)
+					break
+				}
+				if route.Srv.unknownHostF != nil {
+					route.Srv.unknownHostF(route)
+					break
+				}
 				route.Error(http.StatusBadRequest, "Invalid direct IP access")
 			}
 
 		case err_subdomain_not_found:
 			route.Error(http.StatusBadRequest, fmt.Sprintf("Subdomain \"%s\" not found", route.SubdomainName))
+
+		case err_missing_host:
+			route.Error(http.StatusBadRequest, "Missing Host header")
+
+		case err_server_options:
+			route.W.Header().Set("Allow", strings.Join(ServerOptionsMethods, ", "))
+			route.W.Header().Set("Content-Length", "0")
+			route.W.WriteHeader(http.StatusOK)
+
+		case err_connect_method:
+			route.W.Header().Set("Allow", strings.Join(ServerOptionsMethods, ", "))
+			route.Error(http.StatusMethodNotAllowed, "CONNECT method not supported")
 		}
 
 		return
 	}
 
+	route.overrideMethod()
+
+	if !route.Subdomain.isMethodAllowed(route.Method) {
+		route.W.Header().Set("Allow", strings.Join(route.Subdomain.allowedMethods, ", "))
+		route.Error(http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
 	if value, ok := route.Website.PageHeaders[route.RequestURI]; ok {
 		for _, h := range value {
 			route.W.Header().Add(h[0], h[1])
 		}
 	}
 
-	route.Subdomain.serveF(route)
+	for _, asset := range route.Website.PushAssets[route.RequestURI] {
+		if err := route.Push(asset, nil); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			route.Logger.Printf(logger.LOG_LEVEL_WARNING, "Error pushing asset %s: %v", asset, err)
+		}
+	}
+
+	if route.ExpectsContinue() && route.Srv.expectContinueF != nil {
+		if code, ok := route.Srv.expectContinueF(route); !ok {
+			route.CloseConnection()
+			route.Error(code, http.StatusText(code), "Request body rejected before being sent by the client")
+			return
+		}
+	}
+
+	if sr, ok := route.Subdomain.staticResps[route.RequestURI]; ok {
+		route.serveStaticResponse(sr)
+		return
+	}
+
+	release, ok := route.admit()
+	if !ok {
+		return
+	}
+	defer release()
+
+	if cancel := route.applyTimeout(); cancel != nil {
+		defer cancel()
+	}
+
+	route.callServeF()
+
+	if route.W.code == 0 && !route.W.hasWrote && errors.Is(route.R.Context().Err(), context.DeadlineExceeded) {
+		route.Error(http.StatusServiceUnavailable, "Request timeout", "The serve function exceeded the timeout")
+		return
+	}
 
 	if route.W.code == 0 {
 		route.W.WriteHeader(200)
 	}
 }
 
+// serveStaticResponse serves a precomputed response registered in the
+// subdomain (see Subdomain.ServeStaticResponse). The body is never
+// captured, even with an error status code
+func (route *Route) serveStaticResponse(sr *staticResponse) {
+	header := route.W.Header()
+	header.Set("Content-Type", sr.contentType)
+	header.Set("ETag", sr.etag)
+
+	if sr.status == http.StatusOK && route.R.Header.Get("If-None-Match") == sr.etag {
+		route.W.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(sr.body)))
+	route.W.disableErrorCapture = true
+	route.W.WriteHeader(sr.status)
+
+	if route.Method != http.MethodHead {
+		route.ServeData(sr.body)
+	}
+}
+
+// applyTimeout sets the deadline of the request context, if a timeout
+// is set in the subdomain or in the server (see Subdomain.SetTimeout)
+func (route *Route) applyTimeout() context.CancelFunc {
+	timeout := route.Srv.handlerTimeout
+	if route.Subdomain.timeout != 0 {
+		timeout = route.Subdomain.timeout
+	}
+	if timeout <= 0 {
+		return nil
+	}
+
+	route.parentCtx = route.R.Context()
+	ctx, cancel := context.WithTimeout(route.parentCtx, timeout)
+	route.R = route.R.WithContext(ctx)
+
+	return cancel
+}
+
+// callServeF calls the serve function of the subdomain, recovering
+// from the aborts of Route.Must: any other panic is propagated
+func (route *Route) callServeF() {
+	defer func() {
+		if p := recover(); p != nil {
+			abort, ok := p.(routeAbort)
+			if !ok {
+				panic(p)
+			}
+
+			route.Error(http.StatusInternalServerError, abort.clientMsg, abort.err)
+		}
+	}()
+
+	route.Subdomain.serveF(route)
+}
+
+// DisableTimeout removes the deadline set on the request context by the
+// subdomain or server timeout (see Subdomain.SetTimeout). This is useful
+// for long running serve functions, like streaming ones
+func (route *Route) DisableTimeout() {
+	if route.parentCtx != nil {
+		route.R = route.R.WithContext(route.parentCtx)
+	}
+}
+
+// redirectToCanonicalHost redirects the connection to the canonical host
+// of the domain (see Domain.SetCanonicalHost), if needed, and reports
+// whether the redirect was done
+func (route *Route) redirectToCanonicalHost() bool {
+	if route.Domain == nil || route.Domain.canonicalHost == CANONICAL_HOST_NONE || route.IsInternalConn() {
+		return false
+	}
+
+	hostname := route.Hostname()
+	if net.ParseIP(hostname) != nil || !strings.Contains(hostname, ".") {
+		return false
+	}
+
+	hasWWW := strings.HasPrefix(strings.ToLower(route.Host), "www.")
+
+	var host string
+	switch route.Domain.canonicalHost {
+	case CANONICAL_HOST_STRIP_WWW:
+		if !hasWWW {
+			return false
+		}
+		host = route.Host[len("www."):]
+	case CANONICAL_HOST_FORCE_WWW:
+		if hasWWW {
+			return false
+		}
+		host = "www." + route.Host
+	default:
+		return false
+	}
+
+	http.Redirect(route.W, route.R, route.Scheme()+"://"+host+route.R.RequestURI, http.StatusMovedPermanently)
+	return true
+}
+
+// OnFinish registers a function called after the connection is handled
+// and the response is sent, with the final metrics of the connection. The
+// functions are called in a separate goroutine, in the order they were registered,
+// so they don't delay the response: they can be used for example for asynchronous
+// logging or to release resources. Panics in the functions are captured and logged
+func (route *Route) OnFinish(f func(m Metrics)) {
+	route.onFinish = append(route.onFinish, f)
+}
+
+// runFinishCallbacks starts the goroutine running the
+// functions registered with Route.OnFinish, if any
+func (route *Route) runFinishCallbacks() {
+	if len(route.onFinish) == 0 {
+		return
+	}
+
+	m := route.getMetrics()
+	go func() {
+		for _, f := range route.onFinish {
+			err := logger.PanicToErr(func() error {
+				f(m)
+				return nil
+			})
+			if err != nil {
+				route.Logger.Printf(logger.LOG_LEVEL_ERROR, "Panic captured in finish callback: %v", err.Error())
+			}
+		}
+	}()
+}
+
 // getMetrics returns a view of the Route captured connection metrics
-func (route *Route) getMetrics() metrics {
-	return metrics{
+func (route *Route) getMetrics() Metrics {
+	return Metrics{
 		Code:     route.W.code,
 		Duration: time.Since(route.ConnectionTime),
 		Written:  route.W.written,
 	}
 }
 
+// isBotPage tells whether the request uri is one of the
+// pages automatically requested by bots and browsers
+func isBotPage(requestURI string) bool {
+	return requestURI == "/favicon.ico" || requestURI == "/robots.txt"
+}
+
 // avoidNoLogPages check if the requestURI matches any of the
 // NoLogPages set by the website and tells Route to not log
 // if a match is found
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newEmptyTestServer creates an HTTP server through a new Router,
+// without starting it and without any domain registered. A secure
+// server is created without any certificate
+func newEmptyTestServer(t *testing.T, secure bool) *HTTPServer {
+	t.Helper()
+
+	router, err := NewRouter(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating router: %v", err)
+	}
+
+	srv, err := router.NewHTTPServer("", 0, secure, "")
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	srv.Online = true
+
+	return srv
+}
+
+// newTestServer creates an HTTP server through a new Router, without
+// starting it, with a default route configured as given. If the website
+// directory is not set, a temporary directory is used
+func newTestServer(t *testing.T, c SubdomainConfig) *HTTPServer {
+	t.Helper()
+	return registerTestRoute(t, newEmptyTestServer(t, false), c)
+}
+
+// newSecureTestServer is like newTestServer, but the server is
+// created as secure (HTTPS), without any certificate
+func newSecureTestServer(t *testing.T, c SubdomainConfig) *HTTPServer {
+	t.Helper()
+	return registerTestRoute(t, newEmptyTestServer(t, true), c)
+}
+
+// registerTestRoute registers the default route of the server
+// with the configuration given, setting the website defaults
+func registerTestRoute(t *testing.T, srv *HTTPServer, c SubdomainConfig) *HTTPServer {
+	if c.Website.Name == "" {
+		c.Website.Name = "Test"
+	}
+	if c.Website.Dir == "" {
+		c.Website.Dir = t.TempDir()
+	}
+
+	srv.RegisterDefaultRoute("Test", c)
+	return srv
+}
+
+// doTestRequest passes the request to the server handler, like
+// the http.Server would do, and returns the recorded response
+func doTestRequest(srv *HTTPServer, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.Server.Handler.ServeHTTP(rec, req)
+	return rec
+}
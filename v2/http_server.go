@@ -3,12 +3,18 @@ package server
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"embed"
 	"errors"
 	"fmt"
 	"html/template"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/securecookie"
@@ -46,7 +52,66 @@ type HTTPServer struct {
 	secureCookie     *securecookie.SecureCookie
 	secureCookiePerm *securecookie.SecureCookie
 	headers          http.Header
-	errTemplate      *template.Template
+	errTemplate      atomic.Pointer[template.Template]
+	keepAlives       bool
+	hostResolver     HostResolver
+	trackLatency     bool
+	expectContinueF  ExpectContinueFunction
+	proxyConfig      *proxyConfig
+	pauseM           sync.Mutex
+	resumeC          chan struct{}
+	pauseHold        time.Duration
+	cookieNamesM     sync.RWMutex
+	cookieNames      map[string]string
+	noErrorCapture   bool
+	handlerTimeout   time.Duration
+	cookieSalt       string
+	unknownHostF     ServeFunction
+	directIPF        ServeFunction
+	logTLSInfo       bool
+	connStateHook    ConnStateHook
+	listenerM        sync.Mutex
+	listener         net.Listener
+	middlewares      []Middleware
+	missingHost      MissingHostMode
+	missingHostName  string
+	proxyProtocol    bool
+	proxyProtoTrust  []*net.IPNet
+	requests         requestCounters
+	respCache        *responseCache
+	proxyTimeout     time.Duration
+	rewriteRules     []rewriteRule
+	urlRewriter      URLRewriter
+	errorObserver    ErrorObserver
+	errTmplPath      string
+}
+
+// SetProxyTimeout sets the maximum time the upstream requests made by Route.ReverseProxy
+// (and so by Route.ProxyTo) wait for the response headers: after that, the upstream
+// request is canceled and a 504 Gateway Timeout error is served. Once the headers are
+// received, the response body is streamed without a time limit. Zero or less means no
+// timeout, which is the default, but the upstream request is always canceled when
+// the client disconnects
+func (srv *HTTPServer) SetProxyTimeout(d time.Duration) *HTTPServer {
+	srv.proxyTimeout = d
+	return srv
+}
+
+// SetCookieSalt sets the salt mixed with the cookie names before hashing
+// them (see Route.SetCookie), so that different deployments or applications
+// sharing the same domain produce different cookie names even when using
+// the same names in code. By default no salt is used.
+//
+// Changing the salt changes every cookie name: the cookies already
+// set on the clients will be ignored and eventually expire, so, for example,
+// the users will have to log in again. It should be set before the server starts
+func (srv *HTTPServer) SetCookieSalt(salt string) *HTTPServer {
+	srv.cookieNamesM.Lock()
+	defer srv.cookieNamesM.Unlock()
+
+	srv.cookieSalt = salt
+	srv.cookieNames = make(map[string]string)
+	return srv
 }
 
 // Certificate rapresents a standard PEM certicate composed of a
@@ -98,6 +163,7 @@ func newHTTPServer(address string, port int, secure bool, path string, certs []C
 
 	srv.Server.Addr = fmt.Sprintf(":%d", port)
 	srv.setHandler()
+	srv.Server.DisableGeneralOptionsHandler = true
 
 	//Setting up Redirect Server parameters
 	if secure {
@@ -109,10 +175,13 @@ func newHTTPServer(address string, port int, secure bool, path string, certs []C
 	}
 
 	srv.Logger = logger.DefaultLogger
+	srv.Server.ErrorLog = log.New(httpErrorLogWriter{srv}, "", 0)
+
+	srv.Server.ConnState = srv.onConnState
 
 	srv.Server.ReadHeaderTimeout = time.Second * 10
 	srv.Server.IdleTimeout = time.Second * 30
-	srv.Server.SetKeepAlivesEnabled(true)
+	srv.SetKeepAlivesEnabled(true)
 
 	hashKey := securecookie.GenerateRandomKey(64)
 	if hashKey == nil {
@@ -136,6 +205,8 @@ func newHTTPServer(address string, port int, secure bool, path string, certs []C
 
 	srv.domains = make(map[string]*Domain)
 	srv.headers = make(http.Header)
+	srv.cookieNames = make(map[string]string)
+	srv.respCache = newResponseCache(DefaultResponseCacheSize)
 
 	errorHTMLContent, err := staticFS.ReadFile("static/error.html")
 	if err != nil {
@@ -150,11 +221,160 @@ func newHTTPServer(address string, port int, secure bool, path string, certs []C
 	return srv, nil
 }
 
-// Port returns the TCP port listened by the server
+// httpErrorLogWriter is used as the output of the http.Server ErrorLog,
+// so that the errors reported by the standard library (like TLS handshake
+// errors) are logged with the server Logger and the "http-internal" tag
+type httpErrorLogWriter struct {
+	srv *HTTPServer
+}
+
+// Write is used to implement the io.Writer interface. TLS handshake errors
+// are usually caused by the clients, so they are logged as warnings
+func (w httpErrorLogWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+
+	level := logger.LOG_LEVEL_ERROR
+	if strings.Contains(message, "TLS handshake error") {
+		level = logger.LOG_LEVEL_WARNING
+	}
+
+	w.srv.Logger.Clone(nil, "http-internal").Print(level, message)
+	return len(p), nil
+}
+
+// HostResolver is a function that derives from the request the domain and
+// the subdomain names used to find the Domain and the Subdomain that will
+// handle the connection. The subdomain can be returned with or without the
+// trailing dot and must be an empty string if not present
+type HostResolver func(r *http.Request) (domain, subdomain string)
+
+// SetHostResolver replaces the default logic used to derive the domain and
+// subdomain names from the request Host. This can be used for non-standard setups,
+// for example routing based on a custom header or on the path. Setting it to nil
+// restores the default behaviour
+func (srv *HTTPServer) SetHostResolver(f HostResolver) *HTTPServer {
+	srv.hostResolver = f
+	return srv
+}
+
+// SetHTTP2Enabled enables or disables HTTP/2 for the server. HTTP/2 is
+// only available for secure servers and is enabled by default: when
+// disabled, only HTTP/1.1 is advertised via ALPN. It must be called before
+// the server is started (or restarted, see HTTPServer.Restart)
+func (srv *HTTPServer) SetHTTP2Enabled(v bool) *HTTPServer {
+	if v {
+		srv.Server.TLSNextProto = nil
+		if srv.Server.TLSConfig != nil {
+			srv.Server.TLSConfig.NextProtos = nil
+		}
+		return srv
+	}
+
+	srv.Server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	if srv.Server.TLSConfig != nil {
+		srv.Server.TLSConfig.NextProtos = []string{"http/1.1"}
+	}
+	return srv
+}
+
+// SetHandlerTimeout sets the default timeout for every connection handled by the
+// server, see Subdomain.SetTimeout. A value <= 0 disables it
+func (srv *HTTPServer) SetHandlerTimeout(d time.Duration) *HTTPServer {
+	srv.handlerTimeout = d
+	return srv
+}
+
+// SetErrorCapture enables or disables the error capture for every connection
+// handled by the server. By default, when a serve function reports an error status
+// code (>= 400), the response body is captured and served inside the error template.
+// When disabled, the body written by the serve function is sent as it is: this is
+// useful for API servers that always serve their own error bodies (like JSON).
+// It can also be disabled for a single subdomain (see Subdomain.SetErrorCapture)
+func (srv *HTTPServer) SetErrorCapture(v bool) *HTTPServer {
+	srv.noErrorCapture = !v
+	return srv
+}
+
+// SetExpectContinueHandler sets the function used to validate the requests
+// with the "Expect: 100-continue" header before the body is sent by the
+// client, see ExpectContinueFunction. The 100 Continue response is sent
+// automatically when the serve function starts reading the body
+func (srv *HTTPServer) SetExpectContinueHandler(f ExpectContinueFunction) *HTTPServer {
+	srv.expectContinueF = f
+	return srv
+}
+
+// MissingHostMode tells how a server handles the requests without the Host
+// header (like HTTP/1.0 requests). See the constants for the values accepted
+type MissingHostMode int
+
+const (
+	// MISSING_HOST_DEFAULT_DOMAIN serves the requests with the default
+	// domain of the server, if registered (default)
+	MISSING_HOST_DEFAULT_DOMAIN MissingHostMode = iota
+	// MISSING_HOST_REJECT responds with 400 Bad Request
+	MISSING_HOST_REJECT
+	// MISSING_HOST_DOMAIN serves the requests as if the host was the one
+	// set with HTTPServer.SetMissingHost
+	MISSING_HOST_DOMAIN
+)
+
+// SetMissingHost sets how the requests without the Host header are handled,
+// see MissingHostMode. The host is used only with MISSING_HOST_DOMAIN and can
+// also include a subdomain (like "www.mydomain.com"). If the server has a
+// host resolver (see HTTPServer.SetHostResolver), this is not used
+func (srv *HTTPServer) SetMissingHost(mode MissingHostMode, host string) *HTTPServer {
+	srv.missingHost = mode
+	srv.missingHostName = host
+	return srv
+}
+
+// SetUnknownHostHandler sets the function called for the requests to a
+// domain not registered on the server (when there is no default domain),
+// replacing the default 400 Bad Request response: for example it can redirect
+// to a landing page or respond with 421 Misdirected Request. The Route has
+// placeholder Domain, Subdomain and Website, so the request URI and the
+// host must be used instead. Direct IP accesses are handled by the
+// function set with HTTPServer.SetDirectIPHandler, if set
+func (srv *HTTPServer) SetUnknownHostHandler(f ServeFunction) *HTTPServer {
+	srv.unknownHostF = f
+	return srv
+}
+
+// SetDirectIPHandler sets the function called for the requests made
+// using directly the IP address of the server as the host (when there
+// is no default domain), replacing the default 400 Bad Request response.
+// If not set, the unknown host handler is used, see HTTPServer.SetUnknownHostHandler
+func (srv *HTTPServer) SetDirectIPHandler(f ServeFunction) *HTTPServer {
+	srv.directIPF = f
+	return srv
+}
+
+// Port returns the port the server is listening on: if the server was
+// created with port 0, once started this is the port assigned by the
+// operating system (see HTTPServer.Addr), otherwise the requested one
 func (srv *HTTPServer) Port() int {
+	if addr, ok := srv.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
 	return srv.port
 }
 
+// Addr returns the address the server is listening on, or nil if the server
+// has never been started. The listener is created synchronously by HTTPServer.Start,
+// so this can be used right after starting a server created with port 0
+// to know the actual port, like in integration tests. Bear in mind that a
+// restart with port 0 could get a different port
+func (srv *HTTPServer) Addr() net.Addr {
+	srv.listenerM.Lock()
+	defer srv.listenerM.Unlock()
+
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
+
 // IsRunning tells whether the server is running or not
 func (srv *HTTPServer) IsRunning() bool {
 	return srv.state.GetState() == LCS_STARTED
@@ -195,8 +415,126 @@ func (srv *HTTPServer) Header() http.Header {
 	return srv.headers
 }
 
+// ConnStateHook is called every time a client connection of the server
+// changes state, see http.ConnState and HTTPServer.SetConnStateHook
+type ConnStateHook func(conn net.Conn, state http.ConnState)
+
+// SetConnStateHook sets the function called every time a client connection
+// changes state, so that the connections can be monitored (new, active, idle,
+// hijacked and closed connections). Bear in mind that with the keep-alives disabled
+// (see HTTPServer.SetKeepAlivesEnabled) the connections are closed after every
+// request and never become idle, while the idle ones are closed after the idle
+// timeout (see HTTPServer.SetIdleTimeout). Websocket connections are reported
+// as hijacked and they are not tracked anymore. The function must not block
+func (srv *HTTPServer) SetConnStateHook(f ConnStateHook) *HTTPServer {
+	srv.connStateHook = f
+	return srv
+}
+
+// onConnState is used as the http.Server ConnState function
+func (srv *HTTPServer) onConnState(conn net.Conn, state http.ConnState) {
+	if srv.connStateHook != nil {
+		srv.connStateHook(conn, state)
+	}
+}
+
+// ErrorObserver is called for every error response served by the
+// server, see HTTPServer.SetErrorObserver
+type ErrorObserver func(code int, req *http.Request, header http.Header, internal string)
+
+// SetErrorObserver sets the function called once for every response with a status
+// code of 400 or more, after the status code is known but before the error body
+// is served: it receives the status code, the request, the header of the response
+// and the internal error message (the one written in the logs, see Route.Error).
+// The observer can be used to emit metrics, to alert on error spikes or to add
+// headers to the response (like an error ID), but it can't change the status code
+// nor the body. The headers are ignored if they were already sent, that is when
+// the error capture is disabled and the handler wrote the body itself (see
+// HTTPServer.SetErrorCapture). Panics are recovered and logged. The function
+// should not block
+func (srv *HTTPServer) SetErrorObserver(f ErrorObserver) *HTTPServer {
+	srv.errorObserver = f
+	return srv
+}
+
+// SetKeepAlivesEnabled controls whether HTTP keep-alives are enabled.
+// By default, keep-alives are enabled. The value is kept even after the
+// server is stopped and started again
+func (srv *HTTPServer) SetKeepAlivesEnabled(v bool) *HTTPServer {
+	srv.keepAlives = v
+	srv.Server.SetKeepAlivesEnabled(v)
+	return srv
+}
+
+// SetIdleTimeout sets the maximum amount of time to wait for the next request
+// on a connection when keep-alives are enabled. By default, it is set to 30 seconds;
+// if it's zero, the underlying http.Server uses the read timeout
+func (srv *HTTPServer) SetIdleTimeout(d time.Duration) *HTTPServer {
+	srv.Server.IdleTimeout = d
+	return srv
+}
+
+// Pause pauses the server: the connections are still accepted, but every
+// request is held until the server is resumed (see HTTPServer.Resume), for
+// at most the given hold duration; after that, the request is rejected with a
+// 503 Service Unavailable. This differs from setting the server offline (see
+// HTTPServer.Online), which immediately rejects every request, and is meant
+// to be used for brief reconfigurations without client-visible errors
+func (srv *HTTPServer) Pause(hold time.Duration) {
+	srv.pauseM.Lock()
+	defer srv.pauseM.Unlock()
+
+	if srv.resumeC == nil {
+		srv.resumeC = make(chan struct{})
+	}
+	srv.pauseHold = hold
+}
+
+// Resume resumes the server after a pause, releasing every request
+// that is being held
+func (srv *HTTPServer) Resume() {
+	srv.pauseM.Lock()
+	defer srv.pauseM.Unlock()
+
+	if srv.resumeC != nil {
+		close(srv.resumeC)
+		srv.resumeC = nil
+	}
+}
+
+// IsPaused tells whether the server is paused
+func (srv *HTTPServer) IsPaused() bool {
+	srv.pauseM.Lock()
+	defer srv.pauseM.Unlock()
+
+	return srv.resumeC != nil
+}
+
+// waitResume waits for the server to be resumed, if paused, for at most
+// the pause hold duration. It returns false if the server is still paused
+func (srv *HTTPServer) waitResume() bool {
+	srv.pauseM.Lock()
+	resumeC, hold := srv.resumeC, srv.pauseHold
+	srv.pauseM.Unlock()
+
+	if resumeC == nil {
+		return true
+	}
+
+	timer := time.NewTimer(hold)
+	defer timer.Stop()
+
+	select {
+	case <-resumeC:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
 // Start prepares every domain and subdomain and starts listening
-// on the TCP port
+// on the TCP port. The listener is created before returning, so
+// that the actual address is known, see HTTPServer.Addr
 func (srv *HTTPServer) Start() {
 	if srv.state.AlreadyStarted() {
 		return
@@ -205,33 +543,100 @@ func (srv *HTTPServer) Start() {
 	srv.state.SetState(LCS_STARTING)
 	srv.Online = true
 	srv.OnlineTime = time.Now()
+	srv.Server.SetKeepAlivesEnabled(srv.keepAlives)
 
 	for _, d := range srv.domains {
 		for _, sd := range d.subdomains {
-			sd.start(srv, d)
+			if err := sd.start(srv, d); err != nil {
+				srv.Logger.Printf(logger.LOG_LEVEL_FATAL, "Server startup aborted: %v", err)
+				srv.abortStart()
+				return
+			}
 		}
 	}
 
+	listener, err := srv.listen()
+	if err != nil {
+		srv.Logger.Printf(logger.LOG_LEVEL_FATAL, "Server Error: %v", err)
+		srv.abortStart()
+		return
+	}
+
 	go func() {
+		var err error
 		if srv.Secure {
-			if err := srv.Server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				srv.Logger.Printf(logger.LOG_LEVEL_FATAL, "Server Error: %v", err)
-				srv.Stop()
-			}
+			err = srv.Server.ServeTLS(listener, "", "")
 		} else {
-			if err := srv.Server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				srv.Logger.Printf(logger.LOG_LEVEL_FATAL, "Server Error: %v", err)
-				srv.Stop()
-			}
+			err = srv.Server.Serve(listener)
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			srv.Logger.Printf(logger.LOG_LEVEL_FATAL, "Server Error: %v", err)
+			srv.Stop()
 		}
 	}()
 
 	srv.state.SetState(LCS_STARTED)
 }
 
+// abortStart cleans up the subdomains already initialized when the
+// server startup fails, leaving the server stopped
+func (srv *HTTPServer) abortStart() {
+	srv.Online = false
+	for _, d := range srv.domains {
+		for _, sd := range d.subdomains {
+			sd.stop(srv, d)
+		}
+	}
+	srv.state.SetState(LCS_STOPPED)
+}
+
 // Stop cleans up every domain and subdomain and stops listening
 // on the TCP port
 func (srv *HTTPServer) Stop() {
+	srv.stop(context.Background())
+}
+
+// Restart gracefully stops the server, waiting at most the given timeout
+// for the active connections to be closed (after that they are forcibly closed),
+// and then starts it again. Every subdomain is cleaned up and then initialized again
+// and the underlying http.Server is replaced with a new one with the same
+// configuration, so that changes to the certificates or to the settings are applied
+func (srv *HTTPServer) Restart(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	srv.stop(ctx)
+	srv.Server = cloneHTTPServer(srv.Server)
+	srv.Start()
+}
+
+// cloneHTTPServer returns a new http.Server with the same configuration of
+// the provided one, because an http.Server can't be reused after being shut down
+func cloneHTTPServer(s *http.Server) *http.Server {
+	return &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.Handler,
+		TLSConfig:         s.TLSConfig,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+		TLSNextProto:      s.TLSNextProto,
+		ConnState:         s.ConnState,
+		ErrorLog:          s.ErrorLog,
+		BaseContext:       s.BaseContext,
+		ConnContext:       s.ConnContext,
+
+		DisableGeneralOptionsHandler: s.DisableGeneralOptionsHandler,
+	}
+}
+
+// stop is the implementation of Stop: the context is used for the
+// shutdown of the http.Server, if it expires every connection still
+// active is forcibly closed
+func (srv *HTTPServer) stop(ctx context.Context) {
 	if srv.state.AlreadyStopped() {
 		return
 	}
@@ -247,14 +652,25 @@ func (srv *HTTPServer) Stop() {
 		}
 	}
 
-	if err := srv.Server.Shutdown(context.Background()); err != nil {
-		srv.Logger.Printf(logger.LOG_LEVEL_FATAL,
-			"Server %s shutdown crashed due to: %v",
-			srv.Server.Addr, err.Error(),
-		)
+	if err := srv.Server.Shutdown(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			srv.Logger.Printf(logger.LOG_LEVEL_WARNING,
+				"Server %s shutdown timed out, closing the active connections",
+				srv.Server.Addr,
+			)
+			srv.Server.Close()
+		} else {
+			srv.Logger.Printf(logger.LOG_LEVEL_FATAL,
+				"Server %s shutdown crashed due to: %v",
+				srv.Server.Addr, err.Error(),
+			)
+		}
 	}
 
-	srv.stopChannel <- struct{}{}
+	select {
+	case srv.stopChannel <- struct{}{}:
+	default:
+	}
 	srv.Logger.Printf(logger.LOG_LEVEL_INFO, "Server %s shutdown finished", srv.Server.Addr)
 
 	srv.state.SetState(LCS_STOPPED)
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotentResponse is a response saved by the Idempotency handler,
+// that will be replayed to every request with the same idempotency key
+type IdempotentResponse struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore is used by the Idempotency handler to save the responses.
+// It must be safe for concurrent use
+type IdempotencyStore interface {
+	// Get returns the response saved with the given key, if found
+	Get(key string) (*IdempotentResponse, bool)
+	// Set saves the response with the given key
+	Set(key string, resp *IdempotentResponse)
+}
+
+// NewIdempotencyMemStore returns an in-memory IdempotencyStore that keeps
+// every response for the given amount of time
+func NewIdempotencyMemStore(ttl time.Duration) IdempotencyStore {
+	return &idempotencyMemStore{
+		ttl:     ttl,
+		entries: make(map[string]idempotencyMemEntry),
+	}
+}
+
+type idempotencyMemEntry struct {
+	resp    *IdempotentResponse
+	expires time.Time
+}
+
+type idempotencyMemStore struct {
+	ttl     time.Duration
+	m       sync.Mutex
+	entries map[string]idempotencyMemEntry
+}
+
+func (s *idempotencyMemStore) Get(key string) (*IdempotentResponse, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+
+	return entry.resp, true
+}
+
+func (s *idempotencyMemStore) Set(key string, resp *IdempotentResponse) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, k)
+		}
+	}
+
+	s.entries[key] = idempotencyMemEntry{resp: resp, expires: now.Add(s.ttl)}
+}
+
+// IdempotencyKeyHeader is the request header used by the clients to
+// provide the idempotency key
+var IdempotencyKeyHeader = "Idempotency-Key"
+
+// Idempotency wraps the serve function so that requests with an unsafe method
+// (like POST or PATCH) carrying the Idempotency-Key header are executed only once:
+// the first response (code, headers and body) is saved in the store and then replayed
+// for every other request with the same key, method and request URI. Concurrent
+// requests with the same key wait for the first one to finish.
+//
+// Only successful responses are saved (status code lower than 400), so a failed
+// request can be retried by the client with the same key. Replayed responses
+// have the Idempotent-Replayed header set to "true"
+func Idempotency(store IdempotencyStore, f ServeFunction) ServeFunction {
+	var m sync.Mutex
+	inFlight := make(map[string]chan struct{})
+
+	return func(route *Route) {
+		key := route.R.Header.Get(IdempotencyKeyHeader)
+		if key == "" || isSafeMethod(route.Method) {
+			f(route)
+			return
+		}
+		key = route.Method + " " + route.Host + route.RequestURI + " " + key
+
+		for {
+			if resp, ok := store.Get(key); ok {
+				route.replayIdempotentResponse(resp)
+				return
+			}
+
+			m.Lock()
+			wait, ok := inFlight[key]
+			if !ok {
+				inFlight[key] = make(chan struct{})
+				m.Unlock()
+				break
+			}
+			m.Unlock()
+
+			select {
+			case <-wait:
+			case <-route.R.Context().Done():
+				return
+			}
+		}
+
+		defer func() {
+			m.Lock()
+			close(inFlight[key])
+			delete(inFlight, key)
+			m.Unlock()
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: route.W.w}
+		route.W.w = rec
+		f(route)
+		route.W.w = rec.ResponseWriter
+
+		code := route.W.code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		if code >= 400 {
+			return
+		}
+
+		store.Set(key, &IdempotentResponse{
+			Code:   code,
+			Header: route.W.Header().Clone(),
+			Body:   rec.body.Bytes(),
+		})
+	}
+}
+
+// replayIdempotentResponse serves a previously saved response
+func (route *Route) replayIdempotentResponse(resp *IdempotentResponse) {
+	for key, values := range resp.Header {
+		route.W.Header()[key] = append([]string(nil), values...)
+	}
+	route.W.Header().Set("Idempotent-Replayed", "true")
+
+	route.W.WriteHeader(resp.Code)
+	route.ServeData(resp.Body)
+}
+
+// isSafeMethod tells whether the HTTP method is defined as safe,
+// that is it should not change the state of the server
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// idempotencyRecorder captures the body written to the
+// underlying http.ResponseWriter
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) Write(data []byte) (int, error) {
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nixpare/logger"
+)
+
+// InitFailurePolicy tells what to do when the initialization function of
+// a subdomain fails, see Subdomain.SetInitFailurePolicy. The constants
+// contain the values accepted
+type InitFailurePolicy int
+
+const (
+	// INIT_FAILURE_DISABLE disables the subdomain, so that every request
+	// gets a 503 Service Unavailable error, and the server starts anyway. This is the default
+	INIT_FAILURE_DISABLE InitFailurePolicy = iota
+	// INIT_FAILURE_FAIL_SERVER aborts the server startup: the subdomains already
+	// initialized are cleaned up and the server does not listen for connections
+	INIT_FAILURE_FAIL_SERVER
+	// INIT_FAILURE_RETRY disables the subdomain and calls the initialization
+	// function again in the background with an exponential backoff (starting from
+	// one second up to InitRetryMaxBackoff), enabling the subdomain on success
+	INIT_FAILURE_RETRY
+	// INIT_FAILURE_ERROR_PAGE keeps the subdomain offline and serves a
+	// 503 Service Unavailable error telling that the website failed to start,
+	// using the error template of the subdomain, if set
+	INIT_FAILURE_ERROR_PAGE
+)
+
+// InitRetryMaxBackoff is the maximum wait between two initialization attempts
+// of a subdomain with the INIT_FAILURE_RETRY policy
+var InitRetryMaxBackoff = time.Minute
+
+// SetInitFailurePolicy sets what to do when the initialization function of the
+// subdomain fails, that is when it panics: see the InitFailurePolicy constants for
+// the behaviour of each policy. The failure is always logged with the stack trace
+func (sd *Subdomain) SetInitFailurePolicy(policy InitFailurePolicy) {
+	sd.initPolicy = policy
+}
+
+// InitFailed tells whether the last call of the initialization
+// function of the subdomain failed
+func (sd *Subdomain) InitFailed() bool {
+	return sd.initFailed.Load()
+}
+
+// runInitF calls the initialization function of the subdomain,
+// capturing any panic and logging it
+func (sd *Subdomain) runInitF(srv *HTTPServer, d *Domain) error {
+	if sd.initF == nil {
+		return nil
+	}
+
+	panicErr := logger.PanicToErr(func() error {
+		sd.initF(srv, d, sd, sd.website.Load())
+		return nil
+	})
+	if panicErr == nil {
+		sd.initFailed.Store(false)
+		return nil
+	}
+
+	sd.initFailed.Store(true)
+	srv.Logger.Printf(logger.LOG_LEVEL_ERROR, "Subdomain \"%s\" of domain \"%s\" initialization failed: %v\n%s",
+		sd.Name, d.Name, panicErr.PanicErr, panicErr.Stack,
+	)
+	return fmt.Errorf("subdomain \"%s\" of domain \"%s\" initialization failed: %v", sd.Name, d.Name, panicErr.PanicErr)
+}
+
+// handleInitFailure applies the init failure policy of the subdomain,
+// after the initialization function failed
+func (sd *Subdomain) handleInitFailure(srv *HTTPServer, d *Domain) {
+	switch sd.initPolicy {
+	case INIT_FAILURE_DISABLE, INIT_FAILURE_ERROR_PAGE:
+		sd.Disable()
+	case INIT_FAILURE_RETRY:
+		sd.Disable()
+		go sd.retryInit(srv, d)
+	}
+}
+
+// retryInit calls the initialization function until it succeeds or the
+// subdomain is stopped, waiting more and more between the attempts
+func (sd *Subdomain) retryInit(srv *HTTPServer, d *Domain) {
+	backoff := time.Second
+	for {
+		time.Sleep(backoff)
+		if sd.state.GetState() != LCS_STARTED {
+			return
+		}
+
+		if err := sd.runInitF(srv, d); err == nil {
+			srv.Logger.Printf(logger.LOG_LEVEL_INFO, "Subdomain \"%s\" of domain \"%s\" initialized after retrying", sd.Name, d.Name)
+			sd.Enable()
+			return
+		}
+
+		backoff *= 2
+		if backoff > InitRetryMaxBackoff {
+			backoff = InitRetryMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencySamples is the number of the most recent request durations kept
+// for every subdomain to compute the latency quantiles
+var LatencySamples = 1024
+
+// LatencyQuantiles reports the approximate latency of the most recent
+// requests handled by a subdomain (see LatencySamples)
+type LatencyQuantiles struct {
+	Count int // Count is the number of samples used
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+}
+
+// latencyTracker keeps a bounded ring of the most recent request durations
+type latencyTracker struct {
+	m       sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (lt *latencyTracker) add(d time.Duration) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+
+	if len(lt.samples) < LatencySamples {
+		lt.samples = append(lt.samples, d)
+		return
+	}
+
+	if lt.next >= len(lt.samples) {
+		lt.next = 0
+	}
+	lt.samples[lt.next] = d
+	lt.next++
+}
+
+func (lt *latencyTracker) quantiles() LatencyQuantiles {
+	lt.m.Lock()
+	sorted := make([]time.Duration, len(lt.samples))
+	copy(sorted, lt.samples)
+	lt.m.Unlock()
+
+	if len(sorted) == 0 {
+		return LatencyQuantiles{}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	quantile := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+
+	return LatencyQuantiles{
+		Count: len(sorted),
+		P50:   quantile(0.50),
+		P90:   quantile(0.90),
+		P99:   quantile(0.99),
+	}
+}
+
+// EnableLatencyTracking enables or disables the tracking of the
+// requests latency for every subdomain of the server. See
+// HTTPServer.LatencyQuantiles
+func (srv *HTTPServer) EnableLatencyTracking(v bool) *HTTPServer {
+	srv.trackLatency = v
+	return srv
+}
+
+// LatencyQuantiles returns the latency quantiles of the subdomain registered
+// with the given names. If the subdomain is not found or the tracking
+// is not enabled (see HTTPServer.EnableLatencyTracking), the result is empty
+func (srv *HTTPServer) LatencyQuantiles(domain, subdomain string) LatencyQuantiles {
+	d := srv.Domain(domain)
+	if d == nil {
+		return LatencyQuantiles{}
+	}
+
+	sd := d.Subdomain(subdomain)
+	if sd == nil {
+		return LatencyQuantiles{}
+	}
+
+	return sd.LatencyQuantiles()
+}
+
+// LatencyQuantiles returns the latency quantiles of the subdomain, see
+// HTTPServer.EnableLatencyTracking
+func (sd *Subdomain) LatencyQuantiles() LatencyQuantiles {
+	return sd.latency.quantiles()
+}
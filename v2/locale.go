@@ -0,0 +1,149 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetLocales sets the locales (like "en", "en-GB" or "it") supported by the
+// subdomain, used by Route.Locale to negotiate the language of the response.
+// The first locale is the fallback, used when none of the locales accepted
+// by the client is supported. The optional cookie is the name of a plain cookie
+// that, if present with a supported locale, overrides the Accept-Language header
+func (sd *Subdomain) SetLocales(cookie string, locales ...string) {
+	sd.locales = locales
+	sd.localeCookie = cookie
+}
+
+// Locale returns the locale of the response, chosen between the ones supported
+// by the subdomain (see Subdomain.SetLocales) in this order: the one set with
+// Route.SetLanguage, the one in the locale cookie, the best match of the
+// Accept-Language header and lastly the fallback. A client locale matches a
+// supported one if they are equal (ignoring the case) or if they share the
+// primary language (like "en-US" and "en"). If the subdomain has no locales,
+// an empty string is returned
+func (route *Route) Locale() string {
+	if route.locale != "" || route.Subdomain == nil || len(route.Subdomain.locales) == 0 {
+		return route.locale
+	}
+
+	supported := route.Subdomain.locales
+	route.locale = supported[0]
+
+	if name := route.Subdomain.localeCookie; name != "" {
+		if cookie, err := route.R.Cookie(name); err == nil {
+			if locale, ok := matchLocale(supported, []string{cookie.Value}); ok {
+				route.locale = locale
+				return route.locale
+			}
+		}
+	}
+
+	if locale, ok := matchLocale(supported, parseAcceptLanguage(route.R.Header.Get("Accept-Language"))); ok {
+		route.locale = locale
+	}
+
+	return route.locale
+}
+
+// SetLanguage overrides the locale of the response returned by Route.Locale,
+// for example if the language is part of the request URI
+func (route *Route) SetLanguage(locale string) {
+	route.locale = locale
+}
+
+// parseAcceptLanguage returns the language tags of the Accept-Language header,
+// sorted by their quality value (the ones with q=0 are discarded). The tags
+// with the same quality value keep the order of the header
+func parseAcceptLanguage(header string) []string {
+	type tag struct {
+		name string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || v < 0 || v > 1 {
+				v = 0
+			}
+			q = v
+		}
+
+		if q > 0 {
+			tags = append(tags, tag{name, q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// matchLocale returns the first supported locale that matches one of the
+// wanted ones, in order of preference: for every wanted locale an exact match
+// is searched first and then a match of the primary language. The wildcard "*"
+// matches the fallback locale
+func matchLocale(supported []string, wanted []string) (string, bool) {
+	for _, w := range wanted {
+		if w == "*" {
+			return supported[0], true
+		}
+
+		for _, s := range supported {
+			if strings.EqualFold(w, s) {
+				return s, true
+			}
+		}
+
+		primary, _, _ := strings.Cut(w, "-")
+		for _, s := range supported {
+			sPrimary, _, _ := strings.Cut(s, "-")
+			if strings.EqualFold(primary, sPrimary) {
+				return s, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// LocalizedData is the data passed to the templates by Route.RenderLocalized
+type LocalizedData struct {
+	// Locale is the locale of the response, see Route.Locale
+	Locale string
+	// Data is the data passed to Route.RenderLocalized
+	Data any
+}
+
+// RenderLocalized works like Route.Render, but the template receives a LocalizedData
+// with the locale of the response (see Route.Locale) and the given data, so that it
+// can be used like {{ .Locale }} and {{ .Data.Field }}. The Content-Language header
+// is set to the locale and the Vary header is updated accordingly
+func (route *Route) RenderLocalized(name string, data any) {
+	locale := route.Locale()
+	if locale != "" {
+		route.W.Header().Set("Content-Language", locale)
+		route.AddVary("Accept-Language")
+	}
+
+	route.Render(name, LocalizedData{Locale: locale, Data: data})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"it", []string{"it"}},
+		{"en-US,en;q=0.9,it;q=0.8", []string{"en-US", "en", "it"}},
+		{"it;q=0.5, de;q=0.9, en", []string{"en", "de", "it"}},
+		{"fr;q=0.7, es;q=0.7, en;q=0.8", []string{"en", "fr", "es"}},
+		{"en;q=0, it", []string{"it"}},
+		{"en;q=2, it;q=abc, de", []string{"de"}},
+		{" en-GB ; q=0.4 ,, it ", []string{"it", "en-GB"}},
+		{"*;q=0.1, it", []string{"it", "*"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	supported := []string{"en", "it", "de-CH"}
+
+	tests := []struct {
+		wanted []string
+		want   string
+		ok     bool
+	}{
+		{[]string{"it"}, "it", true},
+		{[]string{"IT"}, "it", true},
+		{[]string{"en-US"}, "en", true},
+		{[]string{"de"}, "de-CH", true},
+		{[]string{"de-DE"}, "de-CH", true},
+		{[]string{"fr", "it"}, "it", true},
+		{[]string{"en-GB", "it"}, "en", true},
+		{[]string{"*"}, "en", true},
+		{[]string{"fr", "es"}, "", false},
+		{nil, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := matchLocale(supported, tt.wanted)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchLocale(%q) = %q, %v, want %q, %v", tt.wanted, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRenderLocalized(t *testing.T) {
+	srv := newEmptyTestServer(t, false)
+	_, sd := srv.RegisterDefaultRoute("Test", SubdomainConfig{
+		Website: Website{Name: "Test", Dir: t.TempDir()},
+		ServeF: func(route *Route) {
+			route.W.Header().Set("Vary", "Origin")
+			route.RenderLocalized("page.html", "world")
+		},
+	})
+	sd.SetLocales("lang", "en", "it", "de-CH")
+
+	err := sd.LoadTemplatesFS(fstest.MapFS{
+		"page.html": {Data: []byte(`{{ .Locale }}: hello {{ .Data }}`)},
+	}, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		accept string
+		cookie string
+		want   string
+	}{
+		{"no header uses the fallback", "", "", "en"},
+		{"unsupported languages use the fallback", "fr, es;q=0.5", "", "en"},
+		{"highest quality value wins", "en;q=0.3, it;q=0.9", "", "it"},
+		{"primary language match", "de-AT", "", "de-CH"},
+		{"rejected languages are skipped", "it;q=0, de;q=0.2", "", "de-CH"},
+		{"cookie overrides the header", "it", "de-CH", "de-CH"},
+		{"unsupported cookie is ignored", "it", "fr", "it"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Language", tt.accept)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "lang", Value: tt.cookie})
+			}
+			rec := doTestRequest(srv, req)
+
+			if got, want := rec.Body.String(), tt.want+": hello world"; got != want {
+				t.Errorf("got body %q, want %q", got, want)
+			}
+			if cl := rec.Header().Get("Content-Language"); cl != tt.want {
+				t.Errorf("got Content-Language %q, want %q", cl, tt.want)
+			}
+			if vary := rec.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Origin, Accept-Language" {
+				t.Errorf("got Vary %q", vary)
+			}
+		})
+	}
+}
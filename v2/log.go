@@ -2,7 +2,9 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nixpare/logger"
@@ -52,8 +54,26 @@ func (route *Route) getLock() string {
 	return lock
 }
 
+// Annotate adds a key-value pair to the context of the connection: this
+// context is never sent to the client, but it's appended to the error message
+// in the logs when the connection results in an error or a panic. This can be
+// used to have richer error logs (like the user ID or the operation performed)
+func (route *Route) Annotate(key string, value any) {
+	route.annotations = append(route.annotations, fmt.Sprintf("%s=%v", key, value))
+}
+
+// fullLogErrMessage returns the error message to be used in the logs
+// with the annotations of the connection, if any
+func (route *Route) fullLogErrMessage() string {
+	if len(route.annotations) == 0 {
+		return route.logErrMessage
+	}
+
+	return route.logErrMessage + " [" + strings.Join(route.annotations, " ") + "]"
+}
+
 // logHTTPInfo logs http request with an exit code < 400
-func (route *Route) logHTTPInfo(m metrics) {
+func (route *Route) logHTTPInfo(m Metrics) {
 	route.Logger.Printf(logger.LOG_LEVEL_INFO, http_info_format,
 		logger.BRIGHT_BLUE_COLOR, route.RemoteAddress, logger.DEFAULT_COLOR,
 		route.getLock(),
@@ -69,7 +89,7 @@ func (route *Route) logHTTPInfo(m metrics) {
 }
 
 // logHTTPWarning logs http request with an exit code >= 400 and < 500
-func (route *Route) logHTTPWarning(m metrics) {
+func (route *Route) logHTTPWarning(m Metrics) {
 	route.Logger.Printf(logger.LOG_LEVEL_WARNING, http_warning_format,
 		logger.BRIGHT_BLUE_COLOR, route.RemoteAddress, logger.DEFAULT_COLOR,
 		route.getLock(),
@@ -81,12 +101,12 @@ func (route *Route) logHTTPWarning(m metrics) {
 		logger.DARK_YELLOW_COLOR, route.Website.Name,
 		route.Domain.Name, logger.DEFAULT_COLOR,
 		logger.BRIGHT_BLUE_COLOR, route.Host, logger.DEFAULT_COLOR,
-		logger.DARK_YELLOW_COLOR, route.logErrMessage, logger.DEFAULT_COLOR,
+		logger.DARK_YELLOW_COLOR, route.fullLogErrMessage(), logger.DEFAULT_COLOR,
 	)
 }
 
 // logHTTPError logs http request with an exit code >= 500
-func (route *Route) logHTTPError(m metrics) {
+func (route *Route) logHTTPError(m Metrics) {
 	route.Logger.Printf(logger.LOG_LEVEL_FATAL, http_error_format,
 		logger.BRIGHT_BLUE_COLOR, route.RemoteAddress, logger.DEFAULT_COLOR,
 		route.getLock(),
@@ -98,11 +118,11 @@ func (route *Route) logHTTPError(m metrics) {
 		logger.DARK_RED_COLOR, route.Website.Name,
 		route.Domain.Name, logger.DEFAULT_COLOR,
 		logger.BRIGHT_BLUE_COLOR, route.Host, logger.DEFAULT_COLOR,
-		logger.DARK_RED_COLOR, route.logErrMessage, logger.DEFAULT_COLOR,
+		logger.DARK_RED_COLOR, route.fullLogErrMessage(), logger.DEFAULT_COLOR,
 	)
 }
 
-func (route *Route) logHTTPPanic(m metrics) {
+func (route *Route) logHTTPPanic(m Metrics) {
 	code := " - "
 	if m.Code != 0 {
 		code = fmt.Sprint(m.Code)
@@ -119,16 +139,39 @@ func (route *Route) logHTTPPanic(m metrics) {
 		logger.DARK_RED_COLOR, route.Website.Name,
 		route.Domain.Name, logger.DEFAULT_COLOR,
 		logger.BRIGHT_BLUE_COLOR, route.Host, logger.DEFAULT_COLOR,
-		logger.DARK_RED_COLOR, route.logErrMessage, logger.DEFAULT_COLOR,
+		logger.DARK_RED_COLOR, route.fullLogErrMessage(), logger.DEFAULT_COLOR,
 	)
 }
 
 // serveError serves the error in a predefines error template (if set) and only
 // if no other information was alredy sent to the ResponseWriter. If there is no
 // error template or if the connection method is different from GET or HEAD, the
-// error message is sent as a plain text
+// error message is sent as a plain text.
+//
+// Every header set by the serve function, even after the error was reported, is
+// preserved: the only header controlled by the error path is the Content-Type,
+// which is set to HTML when serving the error template or to plain text when
+// serving the error message if no other Content-Type was set.
+//
+// If the error capture is disabled (see HTTPServer.SetErrorCapture), the error
+// template is never used and the error message is sent as plain text only if
+// the serve function did not write anything
 func (route *Route) serveError() {
 	route.W.disableErrorCapture = true
+	defer route.W.sendHeader()
+
+	route.observeError()
+
+	if route.W.captureOverflow {
+		return
+	}
+
+	if route.noErrorCapture {
+		if !route.W.hasWrote && route.errMessage != "" {
+			route.serveErrorText()
+		}
+		return
+	}
 
 	if len(route.W.caputedError) != 0 {
 		route.errMessage = string(route.W.caputedError)
@@ -138,8 +181,20 @@ func (route *Route) serveError() {
 		return
 	}
 
+	// the body is replaced, so the length set by the handler is not valid anymore
+	route.W.Header().Del("Content-Length")
+
+	if route.wantsJSONError() {
+		if len(route.W.caputedError) != 0 && json.Valid(route.W.caputedError) {
+			route.ServeData(route.W.caputedError)
+			return
+		}
+		route.serveErrorJSON()
+		return
+	}
+
 	if route.errTemplate == nil {
-		route.ServeText(route.errMessage)
+		route.serveErrorText()
 		return
 	}
 
@@ -158,10 +213,81 @@ func (route *Route) serveError() {
 			return
 		}
 
+		route.W.Header().Set("Content-Type", "text/html; charset=utf-8")
 		route.ServeData(buf.Bytes())
 		return
 	}
 
+	route.serveErrorText()
+}
+
+// observeError calls the error observer of the server, if set
+// (see HTTPServer.SetErrorObserver), recovering from any panic
+func (route *Route) observeError() {
+	observer := route.Srv.errorObserver
+	if observer == nil {
+		return
+	}
+
+	err := logger.PanicToErr(func() error {
+		observer(route.W.code, route.R, route.W.Header(), route.fullLogErrMessage())
+		return nil
+	})
+	if err != nil {
+		route.Logger.Printf(logger.LOG_LEVEL_ERROR, "Error observer panic: %v", err)
+	}
+}
+
+// wantsJSONError tells whether the error must be served as JSON: that
+// is when the response Content-Type was already set to JSON by the
+// handler or when the client accepts JSON but not HTML
+func (route *Route) wantsJSONError() bool {
+	if isJSONMediaType(route.W.Header().Get("Content-Type")) {
+		return true
+	}
+
+	accept := route.R.Header.Get("Accept")
+	return strings.Contains(accept, "json") && !strings.Contains(accept, "text/html")
+}
+
+// isJSONMediaType tells whether the media type is application/json
+// or a structured JSON one, like application/problem+json
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// serveErrorJSON serves the error as a JSON object with the
+// code and message fields, like {"code":404,"message":"Not found"}
+func (route *Route) serveErrorJSON() {
+	data, _ := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{
+		Code:    route.W.code,
+		Message: route.errMessage,
+	})
+
+	if !isJSONMediaType(route.W.Header().Get("Content-Type")) {
+		route.W.Header().Set("Content-Type", "application/json")
+	}
+	route.ServeData(data)
+}
+
+// serveErrorText serves the error message as it is, setting
+// the Content-Type to plain text if not already set. If the
+// Content-Type is JSON, the error is served as JSON instead
+func (route *Route) serveErrorText() {
+	if route.wantsJSONError() {
+		route.serveErrorJSON()
+		return
+	}
+
+	if route.W.Header().Get("Content-Type") == "" {
+		route.W.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
 	route.ServeText(route.errMessage)
 }
 
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeErrorJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		serveF   ServeFunction
+		wantType string
+		wantBody string
+	}{
+		{
+			name: "handler set JSON content type",
+			serveF: func(route *Route) {
+				route.W.Header().Set("Content-Type", "application/json")
+				route.Error(http.StatusBadRequest, "Invalid input")
+			},
+			wantType: "application/json",
+			wantBody: `{"code":400,"message":"Invalid input"}`,
+		},
+		{
+			name: "handler set problem JSON content type",
+			serveF: func(route *Route) {
+				route.W.Header().Set("Content-Type", "application/problem+json")
+				route.Error(http.StatusConflict, "Conflict")
+			},
+			wantType: "application/problem+json",
+			wantBody: `{"code":409,"message":"Conflict"}`,
+		},
+		{
+			name:   "client accepts JSON",
+			accept: "application/json",
+			serveF: func(route *Route) {
+				route.Error(http.StatusNotFound, "Not found")
+			},
+			wantType: "application/json",
+			wantBody: `{"code":404,"message":"Not found"}`,
+		},
+		{
+			name:   "client accepts JSON and HTML",
+			accept: "text/html, application/json",
+			serveF: func(route *Route) {
+				route.Error(http.StatusNotFound, "Not found")
+			},
+			wantType: "text/html; charset=utf-8",
+		},
+		{
+			name: "captured body is valid JSON",
+			serveF: func(route *Route) {
+				route.W.Header().Set("Content-Type", "application/json")
+				route.W.WriteHeader(http.StatusUnprocessableEntity)
+				route.W.Write([]byte(`{"errors":["name is required"]}`))
+			},
+			wantType: "application/json",
+			wantBody: `{"errors":["name is required"]}`,
+		},
+		{
+			name: "captured body is not JSON",
+			serveF: func(route *Route) {
+				route.W.Header().Set("Content-Type", "application/json")
+				route.W.WriteHeader(http.StatusInternalServerError)
+				route.W.Write([]byte(`broken "output"`))
+			},
+			wantType: "application/json",
+			wantBody: `{"code":500,"message":"broken \"output\""}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{ServeF: tt.serveF})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := doTestRequest(srv, req)
+
+			if ct := rec.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("got Content-Type %q, want %q", ct, tt.wantType)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+
+			body := strings.TrimSpace(rec.Body.String())
+			if body != tt.wantBody {
+				t.Errorf("got body %s, want %s", body, tt.wantBody)
+			}
+			if !json.Valid([]byte(body)) {
+				t.Errorf("body %s is not valid JSON", body)
+			}
+		})
+	}
+}
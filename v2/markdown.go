@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// MarkdownRenderer converts Markdown to HTML, see Route.ServeMarkdown.
+// This package does not include a Markdown parser, so that the dependency is
+// optional: a renderer can be easily implemented with any library, for example
+//
+//	server.DefaultMarkdownRenderer = server.MarkdownRendererFunc(func(src []byte) ([]byte, error) {
+//		var buf bytes.Buffer
+//		err := goldmark.Convert(src, &buf)
+//		return buf.Bytes(), err
+//	})
+type MarkdownRenderer interface {
+	RenderMarkdown(src []byte) ([]byte, error)
+}
+
+// MarkdownRendererFunc implements MarkdownRenderer with a function
+type MarkdownRendererFunc func(src []byte) ([]byte, error)
+
+func (f MarkdownRendererFunc) RenderMarkdown(src []byte) ([]byte, error) {
+	return f(src)
+}
+
+// DefaultMarkdownRenderer is the renderer used by Route.ServeMarkdown. If it's
+// nil, the Markdown source is escaped and served as preformatted text
+var DefaultMarkdownRenderer MarkdownRenderer
+
+// MarkdownCacheTTL is how long a page rendered by Route.ServeMarkdown is
+// cached: the cache is invalidated anyway when the file changes
+var MarkdownCacheTTL = time.Hour
+
+// MarkdownPage is the data passed to the layout template by Route.ServeMarkdown
+type MarkdownPage struct {
+	// Title is the first level 1 heading of the page, if any
+	Title string
+	// Path is the path of the Markdown file, relative to the Website.Dir
+	Path    string
+	Content template.HTML
+	ModTime time.Time
+}
+
+// ServeMarkdown serves the Markdown file, which path is relative to the Website.Dir,
+// rendered to HTML with the DefaultMarkdownRenderer: if the layout is not nil,
+// the rendered content is wrapped in the layout, executed with a MarkdownPage, like so:
+//
+//	<html><head><title>{{ .Title }}</title></head><body>{{ .Content }}</body></html>
+//
+// The rendered page is cached (see Route.ServeCachedFunc and MarkdownCacheTTL) and
+// rendered again when the file changes, and an ETag is generated, so that conditional
+// requests are handled automatically. The path can't escape the Website.Dir
+func (route *Route) ServeMarkdown(mdPath string, layout *template.Template) {
+	filePath, err := route.Website.cleanPath(route.Website.Dir + "/" + strings.TrimLeft(mdPath, "/"))
+	if err != nil {
+		route.Error(http.StatusBadRequest, "Bad request URL", err)
+		return
+	}
+	if err = route.Website.checkSymlinks(filePath); err != nil {
+		route.Error(http.StatusNotFound, "Not found", err)
+		return
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() {
+		route.Error(http.StatusNotFound, "Not found")
+		return
+	}
+
+	key := fmt.Sprintf("markdown %s %d %d %p", filePath, info.ModTime().UnixNano(), info.Size(), layout)
+	route.ServeCachedFunc(key, MarkdownCacheTTL, func() ([]byte, string, error) {
+		src, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		content, err := renderMarkdown(src)
+		if err != nil {
+			return nil, "", fmt.Errorf("error rendering markdown: %w", err)
+		}
+
+		if layout == nil {
+			return content, "text/html; charset=utf-8", nil
+		}
+
+		var buf bytes.Buffer
+		err = layout.Execute(&buf, MarkdownPage{
+			Title:   markdownTitle(src),
+			Path:    mdPath,
+			Content: template.HTML(content),
+			ModTime: info.ModTime(),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("error executing markdown layout: %w", err)
+		}
+
+		return buf.Bytes(), "text/html; charset=utf-8", nil
+	})
+}
+
+// renderMarkdown renders the Markdown source with the DefaultMarkdownRenderer
+func renderMarkdown(src []byte) ([]byte, error) {
+	if DefaultMarkdownRenderer == nil {
+		return []byte("<pre>" + template.HTMLEscapeString(string(src)) + "</pre>"), nil
+	}
+	return DefaultMarkdownRenderer.RenderMarkdown(src)
+}
+
+// markdownTitle returns the text of the first level 1 heading
+func markdownTitle(src []byte) string {
+	for _, line := range strings.Split(string(src), "\n") {
+		if title, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+			return strings.TrimSpace(title)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestServeMarkdownDefaultServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/page.md", []byte("# Hello\n\n<b>text</b>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := template.Must(template.New("layout").Parse("<title>{{ .Title }}</title>{{ .Content }}"))
+	srv := newTestServer(t, SubdomainConfig{
+		Website: Website{Dir: dir},
+		ServeF: func(route *Route) {
+			route.ServeMarkdown("page.md", layout)
+		},
+	})
+
+	var etag string
+	for i := 0; i < 2; i++ {
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d", i, rec.Code)
+		}
+
+		body := rec.Body.String()
+		if !strings.Contains(body, "<title>Hello</title>") || !strings.Contains(body, "&lt;b&gt;text&lt;/b&gt;") {
+			t.Errorf("request %d: unexpected body %q", i, body)
+		}
+		if i == 1 && rec.Header().Get("ETag") != etag {
+			t.Errorf("ETag changed from %q to %q", etag, rec.Header().Get("ETag"))
+		}
+		etag = rec.Header().Get("ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec := doTestRequest(srv, req); rec.Code != http.StatusNotModified {
+		t.Errorf("conditional request: got status %d, want 304", rec.Code)
+	}
+}
+
+func TestServeMarkdownNotFound(t *testing.T) {
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			route.ServeMarkdown("missing.md", nil)
+		},
+	})
+
+	if rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MethodOverrideHeader is the request header used by the clients
+// to override the method, see Subdomain.EnableMethodOverride
+var MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// MethodOverrideField is the form field used by the HTML forms
+// to override the method, see Subdomain.EnableMethodOverride
+var MethodOverrideField = "_method"
+
+// EnableMethodOverride lets the clients that can only send GET and POST requests
+// (like HTML forms) use other methods: the POST requests with the MethodOverrideHeader
+// header or with the MethodOverrideField field in an url-encoded form body are
+// handled as if they were made with the method specified (the method of the
+// request is rewritten, see Route.RealMethod). Only the given methods can be used,
+// by default PUT, PATCH and DELETE.
+//
+// The override is honored only for POST requests, so that a safe request (like a
+// GET made by a link or an image on another website) can never become an unsafe one.
+// Bear in mind that a form submitted cross-site can still use the override as any other
+// POST request, so the same protections against CSRF are needed
+func (sd *Subdomain) EnableMethodOverride(methods ...string) {
+	if len(methods) == 0 {
+		methods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+
+	sd.methodOverride = make([]string, 0, len(methods))
+	for _, method := range methods {
+		sd.methodOverride = append(sd.methodOverride, strings.ToUpper(method))
+	}
+}
+
+// DisableMethodOverride disables the method override, see Subdomain.EnableMethodOverride
+func (sd *Subdomain) DisableMethodOverride() {
+	sd.methodOverride = nil
+}
+
+// RealMethod returns the method of the request as sent by the
+// client, before the method override (see Subdomain.EnableMethodOverride)
+func (route *Route) RealMethod() string {
+	return route.R.Method
+}
+
+// overrideMethod applies the method override to the request,
+// if enabled in the subdomain
+func (route *Route) overrideMethod() {
+	if len(route.Subdomain.methodOverride) == 0 || route.Method != http.MethodPost {
+		return
+	}
+
+	method := route.R.Header.Get(MethodOverrideHeader)
+	if method == "" {
+		mediaType, _, _ := mime.ParseMediaType(route.R.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" {
+			route.limitBody()
+			method = route.R.PostFormValue(MethodOverrideField)
+		}
+	}
+	if method == "" {
+		return
+	}
+
+	method = strings.ToUpper(method)
+	for _, allowed := range route.Subdomain.methodOverride {
+		if method == allowed {
+			route.Method = method
+			return
+		}
+	}
+}
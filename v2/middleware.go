@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Middleware wraps an http.Handler with another one, that can run code
+// before and after calling the next handler (or decide not to call it)
+type Middleware func(next http.Handler) http.Handler
+
+// AddMiddleware adds a middleware to every HTTP server of the router, both the
+// existing ones and the ones created later through the router. The middlewares are
+// executed in the order they were added, and the ones of the router are executed
+// before the ones of the server (see HTTPServer.AddMiddleware), before the domain and
+// subdomain logic. The middlewares should be added before starting the router
+func (router *Router) AddMiddleware(mw Middleware) {
+	router.middlewares = append(router.middlewares, mw)
+
+	for _, srv := range router.httpServers {
+		srv.setHandler()
+	}
+}
+
+// AddMiddleware adds a middleware to the server, executed after the ones of the
+// router (see Router.AddMiddleware). The middlewares should be added before
+// starting the server
+func (srv *HTTPServer) AddMiddleware(mw Middleware) *HTTPServer {
+	srv.middlewares = append(srv.middlewares, mw)
+	srv.setHandler()
+	return srv
+}
+
+// applyMiddlewares wraps the handler with the middlewares, so that
+// the first one is the outermost
+func applyMiddlewares(h http.Handler, middlewares []Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
+
+// RequestPredicate tells whether a request matches a condition,
+// see When and the predicates PathPrefix, Method, Host and HeaderEquals
+type RequestPredicate func(r *http.Request) bool
+
+// When returns a middleware that applies mw only to the requests matching the
+// predicate, while the others are passed directly to the next handler. This way
+// a middleware can be limited, for example, to some paths without splitting them
+// in another subdomain:
+//
+//	srv.AddMiddleware(server.When(server.PathPrefix("/admin"), authMiddleware))
+//
+// Bear in mind that the middlewares are executed before the URL rewrite rules
+// and the domain and subdomain logic, so the predicates see the original request
+func When(pred RequestPredicate, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pred(r) {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PathPrefix matches the requests whose URL path starts with the prefix,
+// considering whole path segments: "/admin" matches "/admin" and "/admin/users",
+// but not "/administrator". A prefix ending with a slash is matched as it is.
+// The path is cleaned before matching (like the files served do), so paths like
+// "//admin/users" or "/x/../admin/users" are matched too
+func PathPrefix(prefix string) RequestPredicate {
+	return func(r *http.Request) bool {
+		path := cleanRequestPath(r.URL.Path)
+		if strings.HasSuffix(prefix, "/") {
+			return strings.HasPrefix(path, prefix)
+		}
+
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+}
+
+// cleanRequestPath cleans the URL path of a request, resolving the dot segments
+// and the repeated slashes, but keeping the trailing slash
+func cleanRequestPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if cleaned != "/" && strings.HasSuffix(p, "/") {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// Method matches the requests using one of the given methods
+func Method(methods ...string) RequestPredicate {
+	return func(r *http.Request) bool {
+		for _, method := range methods {
+			if strings.EqualFold(r.Method, method) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Host matches the requests directed to one of the given hosts,
+// ignoring the port and the case
+func Host(hosts ...string) RequestPredicate {
+	return func(r *http.Request) bool {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		for _, h := range hosts {
+			if strings.EqualFold(host, h) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// HeaderEquals matches the requests having the header with exactly the
+// given value. If the value is empty, the header only needs to be present
+func HeaderEquals(name string, value string) RequestPredicate {
+	return func(r *http.Request) bool {
+		values := r.Header.Values(name)
+		if value == "" {
+			return len(values) != 0
+		}
+
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestPathPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   bool
+	}{
+		{"/admin", "/admin", true},
+		{"/admin", "/admin/users", true},
+		{"/admin", "/administrator", false},
+		{"/admin", "/public/admin", false},
+		{"/admin", "//admin/secret", true},
+		{"/admin", "/x/../admin/secret", true},
+		{"/admin", "/./admin/secret", true},
+		{"/admin", "/admin/../public", false},
+		{"/admin/", "/admin/", true},
+		{"/admin/", "/admin/users", true},
+		{"/admin/", "/admin", false},
+		{"/admin/", "//admin//", true},
+		{"/", "", true},
+	}
+
+	for _, tt := range tests {
+		r := &http.Request{URL: &url.URL{Path: tt.path}}
+		if got := PathPrefix(tt.prefix)(r); got != tt.want {
+			t.Errorf("PathPrefix(%q) with %q = %v, want %v", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWhenPathPrefixBypass(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"admin", "public"} {
+		if err := os.Mkdir(dir+"/"+name, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dir+"/"+name+"/secret.txt", []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := newTestServer(t, SubdomainConfig{Website: Website{Dir: dir}})
+	srv.AddMiddleware(When(PathPrefix("/admin"), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}))
+
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"/public/secret.txt", http.StatusOK},
+		{"/admin/secret.txt", http.StatusForbidden},
+		{"//admin/secret.txt", http.StatusForbidden},
+		{"/public/../admin/secret.txt", http.StatusForbidden},
+		{"/public/%2e%2e/admin/secret.txt", http.StatusForbidden},
+		{"/./admin/secret.txt", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.target, rec.Code, tt.code)
+		}
+	}
+}
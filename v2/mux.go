@@ -0,0 +1,330 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TrailingSlashMode tells the path router of a subdomain how to handle a
+// request path that differs from a pattern only for the trailing slash. See
+// the constants for the values accepted
+type TrailingSlashMode int
+
+const (
+	// TRAILING_SLASH_STRICT requires the trailing slash of the
+	// request path to match exactly the one of the pattern
+	TRAILING_SLASH_STRICT TrailingSlashMode = iota
+	// TRAILING_SLASH_IGNORE matches the pattern with or without the trailing slash
+	TRAILING_SLASH_IGNORE
+	// TRAILING_SLASH_REDIRECT redirects the client to the path written
+	// like the pattern, with or without the trailing slash
+	TRAILING_SLASH_REDIRECT
+)
+
+// SlashNormalization tells how a subdomain normalizes the trailing slash of
+// the request paths, before any serve function. See the constants for the
+// values accepted
+type SlashNormalization int
+
+const (
+	// SLASH_NORMALIZE_NONE leaves the request paths as they are (default)
+	SLASH_NORMALIZE_NONE SlashNormalization = iota
+	// SLASH_NORMALIZE_REDIRECT_REMOVE permanently redirects the paths with the
+	// trailing slash to the same path without it (/path/ -> /path)
+	SLASH_NORMALIZE_REDIRECT_REMOVE
+	// SLASH_NORMALIZE_REDIRECT_ADD permanently redirects the paths without the
+	// trailing slash to the same path with it (/path -> /path/)
+	SLASH_NORMALIZE_REDIRECT_ADD
+	// SLASH_NORMALIZE_STRIP removes the trailing slash from the Route.RequestURI
+	// without redirecting, so that /path/ and /path are handled in the same way
+	SLASH_NORMALIZE_STRIP
+)
+
+// SetSlashNormalization sets how the trailing slash of the request paths
+// is normalized for this subdomain, see SlashNormalization. The root path "/"
+// and the paths that look like files (whose last segment contains a dot) are
+// never modified. The redirects use 301 Moved Permanently for GET and HEAD requests
+// and 308 Permanent Redirect otherwise, so that the method and the body are kept
+func (sd *Subdomain) SetSlashNormalization(mode SlashNormalization) {
+	sd.slashNorm = mode
+}
+
+// normalizeSlash applies the trailing slash normalization of the subdomain,
+// returning true if the connection was redirected
+func (route *Route) normalizeSlash() bool {
+	if route.Subdomain == nil || route.Subdomain.slashNorm == SLASH_NORMALIZE_NONE {
+		return false
+	}
+
+	path := route.RequestURI
+	if path == "/" || path == "" {
+		return false
+	}
+
+	hasSlash := strings.HasSuffix(path, "/")
+	lastSegment := path[strings.LastIndex(strings.TrimSuffix(path, "/"), "/")+1:]
+	if strings.Contains(lastSegment, ".") {
+		return false
+	}
+
+	var location string
+	switch route.Subdomain.slashNorm {
+	case SLASH_NORMALIZE_STRIP:
+		route.RequestURI = strings.TrimRight(path, "/")
+		if route.RequestURI == "" {
+			route.RequestURI = "/"
+		}
+		return false
+	case SLASH_NORMALIZE_REDIRECT_REMOVE:
+		if !hasSlash {
+			return false
+		}
+		location = redirectPath(strings.TrimRight(route.R.URL.EscapedPath(), "/"))
+	case SLASH_NORMALIZE_REDIRECT_ADD:
+		if hasSlash {
+			return false
+		}
+		location = redirectPath(route.R.URL.EscapedPath()) + "/"
+	default:
+		return false
+	}
+
+	if route.R.URL.RawQuery != "" {
+		location += "?" + route.R.URL.RawQuery
+	}
+
+	code := http.StatusPermanentRedirect
+	if route.Method == http.MethodGet || route.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	http.Redirect(route.W, route.R, location, code)
+	return true
+}
+
+// redirectPath makes the escaped request path safe to be used as a redirect
+// location: the leading slashes are collapsed into one, so that a path like
+// "//host" cannot become a protocol-relative redirect to another host
+func redirectPath(escapedPath string) string {
+	return "/" + strings.TrimLeft(escapedPath, "/")
+}
+
+// muxRoute is a single pattern registered in the path router
+type muxRoute struct {
+	method        string
+	segments      []string
+	trailingSlash bool
+	f             ServeFunction
+}
+
+// subdomainMux is the path router of a subdomain, see Subdomain.Handle
+type subdomainMux struct {
+	routes        []*muxRoute
+	fallback      ServeFunction
+	trailingSlash TrailingSlashMode
+}
+
+// Handle registers the serve function for the requests with the given method
+// and a path matching the pattern. The first time this method is called, the
+// serve function of the subdomain is replaced by the path router and the previous
+// one is used as a fallback for the requests not matching any pattern (or, if
+// not set, a 404 Not Found error is reported). If the path matches a pattern but
+// not the method, a 405 Method Not Allowed error is reported with the Allow header.
+//
+// The method can be empty (or "*") to match any method and the GET patterns also
+// match the HEAD requests. The pattern segments like "{id}" match any single
+// path segment, while a last segment like "{path...}" matches the rest of the path:
+// the values can be retrieved with Route.PathParam. The patterns are tried in the
+// order they were registered. Example:
+//
+//	sd.Handle("GET", "/users/{id}", func(route *server.Route) {
+//		id, _ := route.PathParam("id")
+//		// ...
+//	})
+func (sd *Subdomain) Handle(method string, pattern string, f ServeFunction) {
+	if sd.mux == nil {
+		sd.mux = &subdomainMux{fallback: sd.serveF}
+		sd.serveF = sd.mux.serve
+	}
+
+	if method == "*" {
+		method = ""
+	}
+
+	sd.mux.routes = append(sd.mux.routes, &muxRoute{
+		method:        strings.ToUpper(method),
+		segments:      splitPathSegments(pattern),
+		trailingSlash: pattern != "/" && strings.HasSuffix(pattern, "/"),
+		f:             f,
+	})
+}
+
+// SetTrailingSlash sets how the path router of the subdomain handles the
+// trailing slash of the request paths, see TrailingSlashMode and Subdomain.Handle.
+// The default is TRAILING_SLASH_STRICT
+func (sd *Subdomain) SetTrailingSlash(mode TrailingSlashMode) {
+	if sd.mux == nil {
+		sd.mux = &subdomainMux{fallback: sd.serveF}
+		sd.serveF = sd.mux.serve
+	}
+
+	sd.mux.trailingSlash = mode
+}
+
+// PathParam returns the value of the path parameter with the given
+// name, matched by the path router of the subdomain (see Subdomain.Handle).
+// The value is already URL-decoded, like the Route.RequestURI, but an encoded
+// slash ("%2F") inside a segment does not split it
+func (route *Route) PathParam(name string) (string, bool) {
+	value, ok := route.pathParams[name]
+	return value, ok
+}
+
+// PathParamInt returns the value of the path parameter with the given name
+// converted to an int, see Route.PathParam. If the parameter is not found
+// or it's not a valid integer, the result is false
+func (route *Route) PathParamInt(name string) (int, bool) {
+	value, ok := route.pathParams[name]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// requestPathSegments returns the decoded segments of the request path.
+// The segments are split before decoding them, so that an encoded slash
+// is kept inside its segment; if the Route.RequestURI was modified and
+// does not correspond to the request path anymore, it's used instead
+func (route *Route) requestPathSegments() []string {
+	escaped := splitPathSegments(route.R.URL.EscapedPath())
+	if unescaped, err := url.PathUnescape(strings.Join(escaped, "/")); err != nil ||
+		unescaped != strings.Trim(route.RequestURI, "/") {
+		return splitPathSegments(route.RequestURI)
+	}
+
+	segments := make([]string, len(escaped))
+	for i, s := range escaped {
+		segments[i], _ = url.PathUnescape(s)
+	}
+
+	return segments
+}
+
+// splitPathSegments returns the segments of the path, without the empty
+// ones at the start and at the end
+func splitPathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+// match tells whether the path segments match the pattern and
+// returns the path parameters found
+func (mr *muxRoute) match(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+
+	for i, s := range mr.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "...}") && i == len(mr.segments)-1 {
+			params[strings.TrimSuffix(s[1:], "...}")] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+
+		if i >= len(segments) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+
+	if len(segments) != len(mr.segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// matchesMethod tells whether the route accepts the method
+func (mr *muxRoute) matchesMethod(method string) bool {
+	return mr.method == "" || mr.method == method ||
+		(mr.method == http.MethodGet && method == http.MethodHead)
+}
+
+// serve is the serve function of a subdomain with a path router
+func (mux *subdomainMux) serve(route *Route) {
+	path := route.RequestURI
+	segments := route.requestPathSegments()
+	trailingSlash := path != "/" && strings.HasSuffix(path, "/")
+
+	var allowed []string
+	for _, mr := range mux.routes {
+		params, ok := mr.match(segments)
+		if !ok {
+			continue
+		}
+
+		if mr.trailingSlash != trailingSlash {
+			switch mux.trailingSlash {
+			case TRAILING_SLASH_STRICT:
+				continue
+			case TRAILING_SLASH_REDIRECT:
+				if !mr.matchesMethod(route.Method) {
+					continue
+				}
+
+				location := redirectPath(strings.TrimSuffix(route.R.URL.EscapedPath(), "/"))
+				if mr.trailingSlash && location != "/" {
+					location += "/"
+				}
+				if route.R.URL.RawQuery != "" {
+					location += "?" + route.R.URL.RawQuery
+				}
+
+				code := http.StatusPermanentRedirect
+				if route.Method == http.MethodGet || route.Method == http.MethodHead {
+					code = http.StatusMovedPermanently
+				}
+				http.Redirect(route.W, route.R, location, code)
+				return
+			}
+		}
+
+		if !mr.matchesMethod(route.Method) {
+			allowed = append(allowed, mr.method)
+			continue
+		}
+
+		route.pathParams = params
+		mr.f(route)
+		return
+	}
+
+	if len(allowed) != 0 {
+		route.W.Header().Set("Allow", strings.Join(allowed, ", "))
+		route.Error(http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if mux.fallback != nil {
+		mux.fallback(route)
+		return
+	}
+
+	route.Error(http.StatusNotFound, "Not found")
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlashNormalizationRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     SlashNormalization
+		target   string
+		code     int
+		location string
+	}{
+		{"add slash", SLASH_NORMALIZE_REDIRECT_ADD, "/docs?page=2", http.StatusMovedPermanently, "/docs/?page=2"},
+		{"add slash keeps files", SLASH_NORMALIZE_REDIRECT_ADD, "/style.css", http.StatusOK, ""},
+		{"add slash to a protocol-relative path", SLASH_NORMALIZE_REDIRECT_ADD, "//3627734734", http.StatusMovedPermanently, "/3627734734/"},
+		{"add slash to many leading slashes", SLASH_NORMALIZE_REDIRECT_ADD, "///evil.example/x", http.StatusMovedPermanently, "/evil.example/x/"},
+		{"remove slash", SLASH_NORMALIZE_REDIRECT_REMOVE, "/docs/", http.StatusMovedPermanently, "/docs"},
+		{"remove slash from a protocol-relative path", SLASH_NORMALIZE_REDIRECT_REMOVE, "//3627734734/", http.StatusMovedPermanently, "/3627734734"},
+		{"remove slash from only slashes", SLASH_NORMALIZE_REDIRECT_REMOVE, "//evil//", http.StatusMovedPermanently, "/evil"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newEmptyTestServer(t, false)
+			_, sd := srv.RegisterDefaultRoute("Test", SubdomainConfig{
+				Website: Website{Name: "Test", Dir: t.TempDir()},
+				ServeF: func(route *Route) {
+					route.ServeText("ok")
+				},
+			})
+			sd.SetSlashNormalization(tt.mode)
+
+			rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.location {
+				t.Errorf("got Location %q, want %q", loc, tt.location)
+			}
+		})
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		target   string
+		code     int
+		location string
+	}{
+		{"remove slash", "/{slug}", "/post/", http.StatusMovedPermanently, "/post"},
+		{"add slash", "/{slug}/", "/post?x=1", http.StatusMovedPermanently, "/post/?x=1"},
+		{"exact match", "/{slug}", "/post", http.StatusOK, ""},
+		{"remove slash from a protocol-relative path", "/{slug}", "//evil.example/", http.StatusMovedPermanently, "/evil.example"},
+		{"add slash to a protocol-relative path", "/{slug}/", "//evil.example", http.StatusMovedPermanently, "/evil.example/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newEmptyTestServer(t, false)
+			_, sd := srv.RegisterDefaultRoute("Test", SubdomainConfig{
+				Website: Website{Name: "Test", Dir: t.TempDir()},
+			})
+			sd.SetTrailingSlash(TRAILING_SLASH_REDIRECT)
+			sd.Handle(http.MethodGet, tt.pattern, func(route *Route) {
+				route.ServeText("ok")
+			})
+
+			rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.location {
+				t.Errorf("got Location %q, want %q", loc, tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorObserver(t *testing.T) {
+	tests := []struct {
+		name      string
+		serveF    ServeFunction
+		wantCalls int
+		wantCode  int
+	}{
+		{
+			name:      "success",
+			serveF:    func(route *Route) { route.ServeText("ok") },
+			wantCalls: 0,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "route error",
+			serveF:    func(route *Route) { route.Error(http.StatusNotFound, "Not found", "missing item") },
+			wantCalls: 1,
+			wantCode:  http.StatusNotFound,
+		},
+		{
+			name: "captured error",
+			serveF: func(route *Route) {
+				route.W.WriteHeader(http.StatusInternalServerError)
+				route.W.Write([]byte("database unavailable"))
+			},
+			wantCalls: 1,
+			wantCode:  http.StatusInternalServerError,
+		},
+		{
+			name: "error reported twice",
+			serveF: func(route *Route) {
+				route.Error(http.StatusBadRequest, "Bad request")
+				route.Error(http.StatusConflict, "Conflict")
+			},
+			wantCalls: 1,
+			wantCode:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := newTestServer(t, SubdomainConfig{ServeF: tt.serveF})
+			want := doTestRequest(plain, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var calls int
+			var gotCode int
+			srv := newTestServer(t, SubdomainConfig{ServeF: tt.serveF})
+			srv.SetErrorObserver(func(code int, req *http.Request, header http.Header, internal string) {
+				calls++
+				gotCode = code
+				header.Set("X-Error-ID", "abc123")
+			})
+
+			rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if calls != tt.wantCalls {
+				t.Fatalf("the observer was called %d times, want %d", calls, tt.wantCalls)
+			}
+			if rec.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != want.Body.String() {
+				t.Errorf("the observer changed the body: got %q, want %q", rec.Body, want.Body)
+			}
+			if tt.wantCalls == 0 {
+				return
+			}
+
+			if gotCode != tt.wantCode {
+				t.Errorf("the observer got status %d, want %d", gotCode, tt.wantCode)
+			}
+			if id := rec.Header().Get("X-Error-ID"); id != "abc123" {
+				t.Errorf("got X-Error-ID %q, want the header added by the observer", id)
+			}
+		})
+	}
+}
+
+func TestErrorObserverPanic(t *testing.T) {
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) { route.Error(http.StatusForbidden, "Forbidden") },
+	})
+	srv.SetErrorObserver(func(code int, req *http.Request, header http.Header, internal string) {
+		panic("observer failure")
+	})
+
+	rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Forbidden") {
+		t.Errorf("got %d %q, want the error response", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OriginCacheOptions configures how the assets fetched from the
+// origin are cached, see Subdomain.ServeFromOrigin
+type OriginCacheOptions struct {
+	// DefaultTTL is how long an asset is cached when the origin
+	// response has no max-age directive in the Cache-Control header
+	DefaultTTL time.Duration
+	// MaxTTL, if set, limits how long an asset is cached, even if
+	// the origin allows more
+	MaxTTL time.Duration
+	// Client is the client used to fetch the assets, by default a
+	// client with a 30 seconds timeout
+	Client *http.Client
+	// MaxSize is the maximum size, in bytes, of an asset body: the bigger
+	// ones are rejected with 502 Bad Gateway. By default it's the size of the
+	// response cache (see HTTPServer.SetResponseCacheSize), or
+	// DefaultResponseCacheSize if the cache is disabled
+	MaxSize int64
+}
+
+// originStatusError is returned when the origin
+// responds with an unexpected status code
+type originStatusError struct {
+	code int
+}
+
+func (err originStatusError) Error() string {
+	return fmt.Sprintf("origin responded with status %d", err.code)
+}
+
+// originTooLargeError is returned when the origin
+// response body exceeds the maximum size
+type originTooLargeError struct {
+	maxSize int64
+}
+
+func (err originTooLargeError) Error() string {
+	return fmt.Sprintf("origin response body larger than %d bytes", err.maxSize)
+}
+
+// ServeFromOrigin sets the serve function of the subdomain so that it acts as
+// a caching layer in front of the origin: every GET and HEAD request is served
+// with the asset fetched from the origin base URL followed by the request URI
+// and the query, which is then cached in memory (see HTTPServer.SetResponseCacheSize),
+// so that the next requests are served locally until it expires. Concurrent requests
+// for the same asset wait for a single fetch.
+//
+// The cache duration follows the Cache-Control header of the origin response
+// (s-maxage or max-age, while no-store, no-cache and private prevent the caching),
+// with the limits set in the options, and the response to the client tells how long
+// it can still be cached. Only the 200 OK responses are cached: the other status codes
+// are reported to the client as errors, as well as the fetch errors and the bodies
+// bigger than the maximum size (502 Bad Gateway)
+func (sd *Subdomain) ServeFromOrigin(originBase string, opts OriginCacheOptions) {
+	originBase = strings.TrimRight(originBase, "/")
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	sd.serveF = func(route *Route) {
+		if route.Method != http.MethodGet && route.Method != http.MethodHead {
+			route.W.Header().Set("Allow", "GET, HEAD")
+			route.Error(http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		maxSize := opts.MaxSize
+		if maxSize <= 0 {
+			maxSize = route.Srv.respCache.maxEntrySize()
+		}
+
+		assetURL := originBase + route.R.URL.RequestURI()
+		resp, err := route.Srv.respCache.getWithTTL("origin "+assetURL, route.Subdomain, func() ([]byte, string, time.Duration, error) {
+			return fetchFromOrigin(client, assetURL, maxSize, opts)
+		})
+		if err != nil {
+			var statusErr originStatusError
+			if errors.As(err, &statusErr) {
+				route.Error(statusErr.code, http.StatusText(statusErr.code), "Origin", assetURL, err)
+				return
+			}
+
+			route.Error(http.StatusBadGateway, "Bad gateway", "Error fetching", assetURL+":", err)
+			return
+		}
+
+		if remaining := time.Until(resp.expires); remaining > 0 {
+			route.CacheControl().Public().MaxAge(remaining).Set()
+		} else {
+			route.CacheControl().NoCache().Set()
+		}
+		route.serveCachedResponse(resp)
+	}
+}
+
+// fetchFromOrigin downloads the asset and returns its body, content type
+// and how long it can be cached, based on the origin Cache-Control header.
+// The body is read up to maxSize bytes, otherwise an error is returned
+func fetchFromOrigin(client *http.Client, assetURL string, maxSize int64, opts OriginCacheOptions) ([]byte, string, time.Duration, error) {
+	resp, err := client.Get(assetURL)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", 0, originStatusError{resp.StatusCode}
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, "", 0, originTooLargeError{maxSize}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", 0, originTooLargeError{maxSize}
+	}
+
+	ttl := originTTL(resp.Header.Get("Cache-Control"), opts.DefaultTTL)
+	if opts.MaxTTL > 0 && ttl > opts.MaxTTL {
+		ttl = opts.MaxTTL
+	}
+
+	return data, resp.Header.Get("Content-Type"), ttl, nil
+}
+
+// originTTL returns how long a response can be cached by a shared cache
+// according to its Cache-Control header, or the default one if not specified
+func originTTL(cacheControl string, defaultTTL time.Duration) time.Duration {
+	ttl := defaultTTL
+	hasSMaxAge := false
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(strings.Trim(value, "\"")); err == nil {
+				ttl = time.Duration(seconds) * time.Second
+				hasSMaxAge = true
+			}
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, "\"")); err == nil && !hasSMaxAge {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return ttl
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServeFromOriginDefaultServer(t *testing.T) {
+	var hits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body{}"))
+	}))
+	defer origin.Close()
+
+	srv := newTestServer(t, SubdomainConfig{})
+	srv.DefaultDomain().DefaultSubdomain().ServeFromOrigin(origin.URL, OriginCacheOptions{DefaultTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/style.css", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "body{}" {
+			t.Fatalf("request %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/css" {
+			t.Errorf("request %d: got Content-Type %q", i, ct)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("origin fetched %d times, want 1", n)
+	}
+
+	if rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/missing", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("missing asset: got status %d, want 404", rec.Code)
+	}
+	if rec := doTestRequest(srv, httptest.NewRequest(http.MethodPost, "/style.css", nil)); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d, want 405", rec.Code)
+	}
+}
+
+func TestServeFromOriginMaxSize(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte("0123456789"))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		name      string
+		cacheSize int64
+		maxSize   int64
+		path      string
+		wantCode  int
+	}{
+		{"within limit", 0, 10, "/small", http.StatusOK},
+		{"content length", 0, 5, "/small", http.StatusBadGateway},
+		{"streamed body", 0, 15, "/chunked", http.StatusBadGateway},
+		{"cache size", 15, 0, "/chunked", http.StatusBadGateway},
+		{"cache size within limit", 15, 0, "/small", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{})
+			if tt.cacheSize > 0 {
+				srv.SetResponseCacheSize(tt.cacheSize)
+			}
+			srv.DefaultDomain().DefaultSubdomain().ServeFromOrigin(origin.URL, OriginCacheOptions{
+				DefaultTTL: time.Minute, MaxSize: tt.maxSize,
+			})
+
+			rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestOriginTTL(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"", time.Minute},
+		{"max-age=30", 30 * time.Second},
+		{"max-age=30, s-maxage=10", 10 * time.Second},
+		{"s-maxage=10, max-age=30", 10 * time.Second},
+		{"public, no-cache", 0},
+		{"private, max-age=30", 0},
+		{"no-store", 0},
+	}
+
+	for _, tt := range tests {
+		if got := originTTL(tt.cacheControl, time.Minute); got != tt.want {
+			t.Errorf("originTTL(%q) = %v, want %v", tt.cacheControl, got, tt.want)
+		}
+	}
+}
@@ -17,24 +17,72 @@ const (
 	err_website_offline                           // The destination website for the request was set to be offline
 	err_domain_not_found                          // The domain pointed by the request was not registered on the server
 	err_subdomain_not_found                       // The domain pointed by the request existed but not the subdomain
+	err_server_paused                             // The destination server was paused for longer than the maximum hold time
+	err_missing_host                              // The request had no Host header and the server rejects these requests
+	err_website_init_failed                       // The destination website initialization failed (see INIT_FAILURE_ERROR_PAGE)
+	err_server_options                            // The request was an "OPTIONS *" asking for the server capabilities
+	err_connect_method                            // The request used the CONNECT method, which is not supported
 )
 
+// ServerOptionsMethods are the methods reported in the Allow header of the responses
+// to the server-wide "OPTIONS *" requests, answered directly by the server with
+// 200 OK and an empty body without involving any domain. The same header is sent
+// when rejecting the CONNECT requests with 405 Method Not Allowed, because the
+// server does not act as a forward proxy (see TCPServer.ProxyTo for tunneling)
+var ServerOptionsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
 // prep contains all the logic that prepares all the fields of
 // Route before being handed over to the connection handler
 // function
 func (route *Route) prep() {
 	route.prepRemoteAddress()
+	route.prepForwarded()
+
+	switch {
+	case route.Method == http.MethodConnect:
+		route.err = err_connect_method
+		route.logRequestURI = route.R.RequestURI
+		route.prepPlaceholders("CONNECT")
+		return
+	case route.Method == http.MethodOptions && route.R.RequestURI == "*":
+		route.err = err_server_options
+		route.logRequestURI = route.R.RequestURI
+		route.prepPlaceholders("Server")
+		return
+	}
+
+	route.rewriteURI()
 
 	err := route.prepRequestURI()
 	if err != nil {
 		route.err = err_bad_url
 		route.logRequestURI = route.R.RequestURI
+		route.prepPlaceholders("Bad Request")
 		return
 	}
 
 	route.prepLogRequestURI()
 
-	route.DomainName, route.SubdomainName = prepDomainAndSubdomainNames(route.R)
+	if route.Srv.hostResolver != nil {
+		domain, subdomain := route.Srv.hostResolver(route.R)
+		route.DomainName, route.SubdomainName = domain, prepSubdomainName(subdomain)
+	} else if strings.TrimSpace(route.R.Host) == "" {
+		switch route.Srv.missingHost {
+		case MISSING_HOST_REJECT:
+			route.err = err_missing_host
+			route.prepPlaceholders("Missing Host")
+			return
+		case MISSING_HOST_DOMAIN:
+			route.DomainName, route.SubdomainName = parseDomainAndSubdomainNames(route.Srv.missingHostName)
+		default:
+			route.DomainName, route.SubdomainName = "", ""
+		}
+	} else {
+		route.DomainName, route.SubdomainName = prepDomainAndSubdomainNames(route.R)
+	}
 	if route.IsInternalConn() {
 		prepDomainAndSubdomainLocal(route)
 	}
@@ -42,6 +90,14 @@ func (route *Route) prep() {
 	route.err = route.prepDomainAndSubdomain()
 }
 
+// prepPlaceholders links an empty Domain, Subdomain and Website to the
+// Route, used when the request can't be directed to any domain
+func (route *Route) prepPlaceholders(name string) {
+	route.Domain = &Domain{Name: name}
+	route.Subdomain = &Subdomain{Name: ""}
+	route.Website = &Website{Name: name}
+}
+
 // prepRemoteAddress provides the IP address of the connection client
 // without the port
 func (route *Route) prepRemoteAddress() {
@@ -117,18 +173,87 @@ func (route *Route) prepLogRequestURI() {
 	}
 }
 
+// LogHeadersDenylist contains the sensitive request headers that are never
+// logged, even if included in the Website.LogHeaders
+var LogHeadersDenylist = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key",
+}
+
+// maxLogHeaderLength is the maximum length of a header value in the logs
+const maxLogHeaderLength = 200
+
+// prepLogHeaders appends to the preformatted request uri used for logging
+// the request headers selected by the Website (see Website.LogHeaders)
+func (route *Route) prepLogHeaders() {
+	if route.Website == nil || len(route.Website.LogHeaders) == 0 {
+		return
+	}
+
+	var fields []string
+	for _, name := range route.Website.LogHeaders {
+		if isLogHeaderDenied(name) {
+			continue
+		}
+
+		value := route.R.Header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		fields = append(fields, http.CanonicalHeaderKey(name)+": "+sanitizeLogValue(value))
+	}
+
+	if len(fields) != 0 {
+		route.logRequestURI += " {" + strings.Join(fields, ", ") + "}"
+	}
+}
+
+// isLogHeaderDenied tells whether the header is in the LogHeadersDenylist
+func isLogHeaderDenied(name string) bool {
+	for _, denied := range LogHeadersDenylist {
+		if strings.EqualFold(name, denied) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sanitizeLogValue removes every control character from the value
+// and truncates it to a maximum length, then quotes it
+func sanitizeLogValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+
+	if len(value) > maxLogHeaderLength {
+		value = value[:maxLogHeaderLength] + "..."
+	}
+
+	return strconv.Quote(value)
+}
+
 // prepDomainAndSubdomainNames parses the incoming request and separates
 // the domain part from the subdomain part, just from a "string" standpoint
 func prepDomainAndSubdomainNames(r *http.Request) (string, string) {
-	host, _, err := net.SplitHostPort(r.Host)
+	return parseDomainAndSubdomainNames(r.Host)
+}
+
+// parseDomainAndSubdomainNames splits the host (with or
+// without the port) in the domain and subdomain names
+func parseDomainAndSubdomainNames(hostport string) (string, string) {
+	host, _, err := net.SplitHostPort(hostport)
 	if err != nil {
-		host, _, err = net.SplitHostPort(r.Host + ":0")
+		host, _, err = net.SplitHostPort(hostport + ":0")
 		if err != nil {
-			return r.Host, ""
+			return hostport, ""
 		}
 	}
 
-	if strings.HasSuffix(host, "127.0.0.1") || strings.HasSuffix(host, "::1") {
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
 		return "localhost", ""
 	}
 
@@ -197,7 +322,7 @@ func (route *Route) prepDomainAndSubdomain() routePrepError {
 		}
 	}
 
-	route.Website = route.Subdomain.website
+	route.Website = route.Subdomain.website.Load()
 	return err_no_err
 }
 
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveWebsiteName serves the name of the website handling the request
+func serveWebsiteName(route *Route) {
+	route.ServeText(route.Website.Name)
+}
+
+func TestMissingHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     MissingHostMode
+		host     string
+		wantCode int
+		wantBody string
+	}{
+		{"default domain", MISSING_HOST_DEFAULT_DOMAIN, "", http.StatusOK, "Default"},
+		{"reject", MISSING_HOST_REJECT, "", http.StatusBadRequest, ""},
+		{"fixed domain", MISSING_HOST_DOMAIN, "www.example.com", http.StatusOK, "Example"},
+		{"fixed domain with port", MISSING_HOST_DOMAIN, "www.example.com:8080", http.StatusOK, "Example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{
+				Website: Website{Name: "Default"},
+				ServeF:  serveWebsiteName,
+			})
+			srv.RegisterDomain("Example", "example.com").RegisterSubdomain("www", SubdomainConfig{
+				Website: Website{Name: "Example", Dir: t.TempDir()},
+				ServeF:  serveWebsiteName,
+			})
+			srv.SetMissingHost(tt.mode, tt.host)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = ""
+			rec := doTestRequest(srv, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("got body %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMissingHostWithoutDefaultDomain(t *testing.T) {
+	srv := newEmptyTestServer(t, false)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = ""
+	if rec := doTestRequest(srv, req); rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", rec.Code)
+	}
+}
+
+func TestIPLiteralHost(t *testing.T) {
+	hosts := []string{"192.0.2.1", "192.0.2.1:8080", "[2001:db8::1]", "[2001:db8::1]:8080"}
+
+	t.Run("default domain", func(t *testing.T) {
+		srv := newTestServer(t, SubdomainConfig{
+			Website: Website{Name: "Default"},
+			ServeF:  serveWebsiteName,
+		})
+
+		for _, host := range hosts {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = host
+			rec := doTestRequest(srv, req)
+
+			if rec.Code != http.StatusOK || rec.Body.String() != "Default" {
+				t.Errorf("%s: got %d %q, want the default domain", host, rec.Code, rec.Body.String())
+			}
+		}
+	})
+
+	t.Run("direct IP handler", func(t *testing.T) {
+		srv := newEmptyTestServer(t, false)
+		srv.SetDirectIPHandler(func(route *Route) {
+			route.W.WriteHeader(http.StatusMisdirectedRequest)
+		})
+		srv.SetUnknownHostHandler(func(route *Route) {
+			route.W.WriteHeader(http.StatusTeapot)
+		})
+
+		for _, host := range hosts {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = host
+			if rec := doTestRequest(srv, req); rec.Code != http.StatusMisdirectedRequest {
+				t.Errorf("%s: got status %d, want 421", host, rec.Code)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = "unknown.example.com"
+		if rec := doTestRequest(srv, req); rec.Code != http.StatusTeapot {
+			t.Errorf("unknown host: got status %d, want 418", rec.Code)
+		}
+	})
+
+	t.Run("no handler", func(t *testing.T) {
+		srv := newEmptyTestServer(t, false)
+
+		for _, host := range hosts {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = host
+			if rec := doTestRequest(srv, req); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: got status %d, want 400", host, rec.Code)
+			}
+		}
+	})
+}
+
+func TestParseDomainAndSubdomainNames(t *testing.T) {
+	tests := []struct {
+		hostport, domain, subdomain string
+	}{
+		{"example.com", "example.com", ""},
+		{"www.example.com:443", "example.com", "www."},
+		{"a.b.example.com", "example.com", "a.b."},
+		{"127.0.0.1:8080", "localhost", ""},
+		{"[::1]:8080", "localhost", ""},
+		{"[::ffff:127.0.0.1]", "localhost", ""},
+		{"192.0.2.1", "192.0.2.1", ""},
+		{"[2001:db8::1]:8080", "2001:db8::1", ""},
+		{"2001:db8::1", "2001:db8::1", ""},
+		{"www.localhost", "localhost", "www."},
+	}
+
+	for _, tt := range tests {
+		domain, subdomain := parseDomainAndSubdomainNames(tt.hostport)
+		if domain != tt.domain || subdomain != tt.subdomain {
+			t.Errorf("parseDomainAndSubdomainNames(%q) = %q, %q, want %q, %q",
+				tt.hostport, domain, subdomain, tt.domain, tt.subdomain)
+		}
+	}
+}
+
+func TestSubdomainSetDirConcurrent(t *testing.T) {
+	srv := newEmptyTestServer(t, false)
+	_, sd := srv.RegisterDefaultRoute("Test", SubdomainConfig{
+		Website: Website{Name: "Test", Dir: t.TempDir()},
+		ServeF: func(route *Route) {
+			route.ServeText(route.Website.Dir)
+		},
+	})
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sd.SetDir(dirs[i%2])
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d", i, rec.Code)
+		}
+	}
+	<-done
+
+	rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Body.String(); got != dirs[1] {
+		t.Errorf("got dir %q, want %q", got, dirs[1])
+	}
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/nixpare/logger"
 	"github.com/nixpare/process"
@@ -45,15 +47,21 @@ func (tm *TaskManager) FindProcess(name string) (*process.Process, error) {
 //
 // Also, this function starts the Process enabling the pipe for the standard
 // input and the capture of the standard output, disables any real
-// input/output and automatically logs an error if the exit status is not
-// successfull. You can always manually call the Start method on the Process
+// input/output (the output is kept only if enabled with SetProcessOutputCapture)
+// and automatically logs an error if the exit status is not successfull.
+// You can always manually call the Start method on the Process
 func (tm *TaskManager) StartProcess(name string) error {
 	p, err := tm.FindProcess(name)
 	if err != nil {
 		return err
 	}
 
-	err = p.Start(process.DevNull(), process.DevNull(), process.DevNull())
+	var out io.Writer = process.DevNull()
+	if capture := tm.processCapture(name); capture != nil {
+		out = capture
+	}
+
+	err = p.Start(process.DevNull(), out, out)
 	if err != nil {
 		return err
 	}
@@ -151,6 +159,54 @@ func (tm *TaskManager) GetProcessesNames() []string {
 	return names
 }
 
+// SetProcessOutputCapture enables the capture of the last size bytes of the output
+// (both standard output and standard error) of the process with the given name,
+// so that it can be retreived for debugging with ProcessOutput without growing
+// unbounded. A size <= 0 disables the capture. The change is applied the next
+// time the process is started with StartProcess
+func (tm *TaskManager) SetProcessOutputCapture(name string, size int) error {
+	_, err := tm.FindProcess(name)
+	if err != nil {
+		return err
+	}
+
+	tm.capturesM.Lock()
+	defer tm.capturesM.Unlock()
+
+	if size <= 0 {
+		delete(tm.captures, name)
+		return nil
+	}
+
+	tm.captures[name] = newRingBuffer(size)
+	return nil
+}
+
+// ProcessOutput returns the last bytes of the output of the process with
+// the given name, if the capture is enabled (see SetProcessOutputCapture)
+func (tm *TaskManager) ProcessOutput(name string) ([]byte, error) {
+	_, err := tm.FindProcess(name)
+	if err != nil {
+		return nil, err
+	}
+
+	capture := tm.processCapture(name)
+	if capture == nil {
+		return nil, fmt.Errorf("process \"%s\" output capture is not enabled", name)
+	}
+
+	return capture.Bytes(), nil
+}
+
+// processCapture returns the buffer capturing the output of the
+// process with the given name, or nil if the capture is not enabled
+func (tm *TaskManager) processCapture(name string) *ringBuffer {
+	tm.capturesM.Lock()
+	defer tm.capturesM.Unlock()
+
+	return tm.captures[name]
+}
+
 // checkProcessName checks if a new process can be created with the giver name. If there is an
 // already registered process with the same name, it returns false, otherwise
 // it returns true
@@ -158,3 +214,57 @@ func (tm *TaskManager) checkProcessName(name string) bool {
 	_, exists := tm.processes[name]
 	return !exists
 }
+
+// ringBuffer is an io.Writer that keeps only the last
+// bytes written, up to its size. It's safe for concurrent use
+type ringBuffer struct {
+	m     sync.Mutex
+	data  []byte
+	start int
+	full  bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, size)}
+}
+
+// Write is used to implement the io.Writer interface
+func (rb *ringBuffer) Write(p []byte) (int, error) {
+	rb.m.Lock()
+	defer rb.m.Unlock()
+
+	n := len(p)
+	if n >= len(rb.data) {
+		copy(rb.data, p[n-len(rb.data):])
+		rb.start = 0
+		rb.full = true
+		return n, nil
+	}
+
+	written := copy(rb.data[rb.start:], p)
+	if written < n {
+		copy(rb.data, p[written:])
+		rb.full = true
+	}
+
+	rb.start = (rb.start + n) % len(rb.data)
+	if rb.start == 0 {
+		rb.full = true
+	}
+
+	return n, nil
+}
+
+// Bytes returns a copy of the bytes stored, from the oldest to the newest
+func (rb *ringBuffer) Bytes() []byte {
+	rb.m.Lock()
+	defer rb.m.Unlock()
+
+	if !rb.full {
+		return append([]byte(nil), rb.data[:rb.start]...)
+	}
+
+	b := make([]byte, 0, len(rb.data))
+	b = append(b, rb.data[rb.start:]...)
+	return append(b, rb.data[:rb.start]...)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"empty", 8, nil, ""},
+		{"partial", 8, []string{"abc", "de"}, "abcde"},
+		{"exactly full", 4, []string{"ab", "cd"}, "abcd"},
+		{"wrap around", 4, []string{"abc", "def"}, "cdef"},
+		{"single big write", 4, []string{"abcdefgh"}, "efgh"},
+		{"big write after partial", 4, []string{"xy", "abcdef"}, "cdef"},
+		{"many small writes", 3, []string{"a", "b", "c", "d", "e"}, "cde"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := newRingBuffer(tt.size)
+			for _, w := range tt.writes {
+				if n, err := rb.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+
+			if got := string(rb.Bytes()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingBufferConcurrentWrites(t *testing.T) {
+	rb := newRingBuffer(64)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rb.Write([]byte("line\n"))
+				rb.Bytes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := string(rb.Bytes())
+	if len(got) != 64 || strings.Trim(got, "line\n") != "" {
+		t.Errorf("got %q, want 64 bytes of repeated lines", got)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	runtimePprof "runtime/pprof"
+	"time"
+
+	"github.com/nixpare/logger"
+)
+
+// WriteProfile writes the pprof profile with the given name (like "goroutine",
+// "heap", "allocs", "block", "mutex" or "threadcreate", see pprof.Lookup) to the writer.
+// The "cpu" profile is also accepted: in this case the CPU is profiled for the
+// given duration before returning. This is not exposed through HTTP, so that it
+// can be used only from a trusted channel (like a local pipe or a file)
+func WriteProfile(w io.Writer, name string, d time.Duration) error {
+	if name == "cpu" {
+		if d <= 0 {
+			return fmt.Errorf("invalid cpu profile duration: %v", d)
+		}
+
+		if err := runtimePprof.StartCPUProfile(w); err != nil {
+			return err
+		}
+		time.Sleep(d)
+		runtimePprof.StopCPUProfile()
+		return nil
+	}
+
+	p := runtimePprof.Lookup(name)
+	if p == nil {
+		return fmt.Errorf("profile \"%s\" not found", name)
+	}
+
+	return p.WriteTo(w, 0)
+}
+
+// EnablePprof starts an internal HTTP server, listening only on the
+// localhost address with the given port, serving the standard net/http/pprof
+// handlers under "/debug/pprof/", so that the go tool pprof can be used
+// (e.g. "go tool pprof http://localhost:6060/debug/pprof/heap"). The server
+// is not reachable from other hosts and it's closed when the router is stopped
+func (router *Router) EnablePprof(port int) error {
+	if router.pprofSrv != nil {
+		return errors.New("pprof server already enabled")
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	router.pprofSrv = &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 10}
+	go func(srv *http.Server) {
+		err := srv.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			router.Logger.Printf(logger.LOG_LEVEL_ERROR, "pprof server error: %v", err)
+		}
+	}(router.pprofSrv)
+
+	router.Logger.Printf(logger.LOG_LEVEL_INFO, "pprof server listening on localhost:%d", port)
+	return nil
+}
+
+// DisablePprof closes the pprof server, if enabled (see Router.EnablePprof)
+func (router *Router) DisablePprof() {
+	if router.pprofSrv == nil {
+		return
+	}
+
+	router.pprofSrv.Close()
+	router.pprofSrv = nil
+}
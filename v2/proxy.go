@@ -0,0 +1,285 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ProxyConfig tells the server which reverse proxies in front of it can be
+// trusted and which of the forwarding headers they set must be honored. When
+// a connection comes from a trusted proxy, the headers enabled are used to derive
+// the client address (Route.RemoteAddress), the scheme (Route.Secure, which is also
+// used for the cookies Secure flag) and the host (Route.Host and the domain and
+// subdomain resolution), so that every feature relies on the same trusted source
+type ProxyConfig struct {
+	// TrustedProxies is the list of the IP addresses or CIDR ranges
+	// (like "10.0.0.0/8") of the trusted proxies
+	TrustedProxies []string
+	// Forwarded enables the RFC 7239 Forwarded header. If the header is
+	// present, it takes precedence over the X-Forwarded-* headers
+	Forwarded bool
+	// ForwardedFor enables the X-Forwarded-For header
+	ForwardedFor bool
+	// ForwardedProto enables the X-Forwarded-Proto header
+	ForwardedProto bool
+	// ForwardedHost enables the X-Forwarded-Host header
+	ForwardedHost bool
+}
+
+// proxyConfig is the parsed version of a ProxyConfig
+type proxyConfig struct {
+	ProxyConfig
+	trusted []*net.IPNet
+}
+
+// parseProxyConfig validates the configuration, parsing every trusted proxy
+func parseProxyConfig(cfg ProxyConfig) (*proxyConfig, error) {
+	trusted, err := parseTrustedNets(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConfig{ProxyConfig: cfg, trusted: trusted}, nil
+}
+
+// parseTrustedNets parses a list of IP addresses and CIDR ranges
+func parseTrustedNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address \"%s\"", s)
+			}
+
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range \"%s\": %w", s, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// SetProxyConfig sets the proxy configuration used by every server
+// of the Router that does not have its own (see HTTPServer.SetProxyConfig)
+func (router *Router) SetProxyConfig(cfg ProxyConfig) error {
+	pc, err := parseProxyConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	router.proxyConfig = pc
+	return nil
+}
+
+// SetProxyConfig sets the proxy configuration for the server, overriding
+// the one of the Router. See ProxyConfig
+func (srv *HTTPServer) SetProxyConfig(cfg ProxyConfig) error {
+	pc, err := parseProxyConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	srv.proxyConfig = pc
+	return nil
+}
+
+// isTrusted tells whether the address belongs to a trusted proxy
+func (pc *proxyConfig) isTrusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range pc.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIndex returns the position of the client address in a chain of addresses,
+// ordered from the client to the last proxy: the chain is walked backwards
+// and the first address not belonging to a trusted proxy is the client one.
+// If every address is trusted, the first one is used
+func (pc *proxyConfig) clientIndex(chain []string) int {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !pc.isTrusted(chain[i]) {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// forwardedElement is a single element of the RFC 7239 Forwarded header
+type forwardedElement struct {
+	For   string
+	Proto string
+	Host  string
+}
+
+// parseForwarded parses the RFC 7239 Forwarded header values. The
+// addresses in the "for" parameters are returned without ports and
+// without square brackets
+func parseForwarded(values []string) []forwardedElement {
+	var elements []forwardedElement
+
+	for _, value := range values {
+		for _, el := range strings.Split(value, ",") {
+			var fe forwardedElement
+
+			for _, pair := range strings.Split(el, ";") {
+				key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(v, "\"")
+
+				switch strings.ToLower(key) {
+				case "for":
+					fe.For = stripAddressPort(v)
+				case "proto":
+					fe.Proto = strings.ToLower(v)
+				case "host":
+					fe.Host = v
+				}
+			}
+
+			elements = append(elements, fe)
+		}
+	}
+
+	return elements
+}
+
+// stripAddressPort removes the port and the square brackets
+// of IPv6 addresses from a network address
+func stripAddressPort(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// splitHeaderList returns the trimmed comma separated values of the header
+func splitHeaderList(value string) []string {
+	var list []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// prepForwarded applies the proxy configuration of the server (or the
+// router) to the Route, if the connection comes from a trusted proxy.
+//
+// Every value is taken from the hop the client address settles on, walking the chain
+// backwards through the trusted proxies (see proxyConfig.clientIndex): the scheme and
+// the host are the ones reported by the outermost trusted proxy about the client
+// connection, so the values prepended by the client are never used. Without the
+// Forwarded header, the X-Forwarded-Proto and X-Forwarded-Host values are matched
+// from the right with the trusted proxies of the X-Forwarded-For chain (or with just
+// the last proxy, if not enabled); if they have fewer values, the rightmost one is used
+func (route *Route) prepForwarded() {
+	pc := route.Srv.proxyConfig
+	if pc == nil && route.Router != nil {
+		pc = route.Router.proxyConfig
+	}
+	if pc == nil || !pc.isTrusted(route.RemoteAddress) {
+		return
+	}
+
+	header := route.R.Header
+	var proto, host string
+
+	if fwd := header.Values("Forwarded"); pc.Forwarded && len(fwd) != 0 {
+		elements := parseForwarded(fwd)
+
+		chain := make([]string, 0, len(elements)+1)
+		for _, el := range elements {
+			chain = append(chain, el.For)
+		}
+		chain = append(chain, route.RemoteAddress)
+
+		if i := pc.clientIndex(chain); i < len(elements) {
+			el := elements[i]
+			if el.For != "" {
+				route.RemoteAddress = el.For
+			}
+			proto, host = el.Proto, el.Host
+		}
+	} else {
+		trustedHops := 1
+		if pc.ForwardedFor {
+			var chain []string
+			for _, value := range header.Values("X-Forwarded-For") {
+				for _, address := range splitHeaderList(value) {
+					chain = append(chain, stripAddressPort(address))
+				}
+			}
+
+			if len(chain) != 0 {
+				chain = append(chain, route.RemoteAddress)
+				i := pc.clientIndex(chain)
+				route.RemoteAddress = chain[i]
+				trustedHops = len(chain) - 1 - i
+			}
+		}
+		if pc.ForwardedProto {
+			proto = strings.ToLower(trustedHopValue(header.Values("X-Forwarded-Proto"), trustedHops))
+		}
+		if pc.ForwardedHost {
+			host = trustedHopValue(header.Values("X-Forwarded-Host"), trustedHops)
+		}
+	}
+
+	switch proto {
+	case "https":
+		route.Secure = true
+	case "http":
+		route.Secure = false
+	}
+
+	if host != "" {
+		route.Host = host
+		route.R.Host = host
+	}
+}
+
+// trustedHopValue returns the value of a forwarding header list appended by
+// the outermost of the given number of trusted proxies, counting from the right.
+// If the list is shorter, the rightmost value is returned
+func trustedHopValue(values []string, trustedHops int) string {
+	var list []string
+	for _, value := range values {
+		list = append(list, splitHeaderList(value)...)
+	}
+	if len(list) == 0 {
+		return ""
+	}
+
+	i := len(list) - trustedHops
+	if i < 0 || trustedHops <= 0 {
+		i = len(list) - 1
+	}
+	return list[i]
+}
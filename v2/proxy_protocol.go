@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyProtocolHeaderTimeout is the maximum time to wait for the PROXY
+// protocol header after a connection is accepted, see HTTPServer.SetProxyProtocol
+var ProxyProtocolHeaderTimeout = time.Second * 5
+
+// errMissingProxyHeader is returned when a connection does not start
+// with a valid PROXY protocol header
+var errMissingProxyHeader = errors.New("missing or invalid PROXY protocol header")
+
+// proxyProtocolV2Signature is the signature starting every
+// PROXY protocol version 2 header
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// SetProxyProtocol enables or disables the parsing of the PROXY protocol header
+// (both version 1 and 2), sent by L4 load balancers (like HAProxy or AWS NLB) at the
+// start of every connection: the client address found in the header is used as the
+// connection remote address, and so for the Route.RemoteAddress, the logs and the
+// internal connection checks. When enabled, every connection must start with the
+// header, otherwise it's closed: this must be enabled only if the server can
+// be reached exclusively through the load balancer, or the load balancers must
+// be listed with HTTPServer.SetProxyProtocolTrusted. It must be set before
+// starting the server
+func (srv *HTTPServer) SetProxyProtocol(v bool) *HTTPServer {
+	srv.proxyProtocol = v
+	return srv
+}
+
+// SetProxyProtocolTrusted sets the IP addresses or CIDR ranges (like "10.0.0.0/8")
+// of the load balancers allowed to send the PROXY protocol header, see
+// HTTPServer.SetProxyProtocol. The connections coming from other sources are served
+// with their own address and their header is not parsed, so that a client cannot
+// spoof its address. With no sources, which is the default, every source is trusted.
+// It must be set before starting the server
+func (srv *HTTPServer) SetProxyProtocolTrusted(sources ...string) error {
+	nets, err := parseTrustedNets(sources)
+	if err != nil {
+		return err
+	}
+
+	srv.proxyProtoTrust = nets
+	return nil
+}
+
+// proxyProtocolListener reads the PROXY protocol header of the accepted
+// connections in a separate goroutine for each of them, so that a client
+// not sending the header does not block the accept loop, and returns them
+// from Accept only after the header was parsed. The connections with an
+// invalid header are closed
+type proxyProtocolListener struct {
+	net.Listener
+	trusted   []*net.IPNet
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newProxyProtocolListener(l net.Listener, trusted []*net.IPNet) *proxyProtocolListener {
+	pl := &proxyProtocolListener{
+		Listener: l,
+		trusted:  trusted,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	go pl.acceptLoop()
+	return pl
+}
+
+// acceptLoop accepts the connections from the underlying listener and starts
+// the header parsing for each of them. The errors are passed to Accept, and
+// the loop exits when the listener is closed
+func (pl *proxyProtocolListener) acceptLoop() {
+	for {
+		conn, err := pl.Listener.Accept()
+		if err != nil {
+			select {
+			case pl.errs <- err:
+			case <-pl.done:
+				return
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		go pl.handshake(conn)
+	}
+}
+
+// handshake reads the PROXY protocol header of the connection, if it comes
+// from a trusted source, and passes the connection to Accept
+func (pl *proxyProtocolListener) handshake(conn net.Conn) {
+	var result net.Conn = conn
+
+	if pl.isTrusted(conn.RemoteAddr()) {
+		r := bufio.NewReader(conn)
+
+		conn.SetReadDeadline(time.Now().Add(ProxyProtocolHeaderTimeout))
+		remote, err := readProxyProtocolHeader(r)
+		conn.SetReadDeadline(time.Time{})
+
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if remote == nil {
+			remote = conn.RemoteAddr()
+		}
+
+		result = &proxyProtocolConn{Conn: conn, r: r, remote: remote}
+	}
+
+	select {
+	case pl.conns <- result:
+	case <-pl.done:
+		conn.Close()
+	}
+}
+
+// isTrusted tells whether the connection source can send the PROXY protocol
+// header: with no trusted sources configured, every source is trusted
+func (pl *proxyProtocolListener) isTrusted(addr net.Addr) bool {
+	if len(pl.trusted) == 0 {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipNet := range pl.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pl *proxyProtocolListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-pl.conns:
+		return conn, nil
+	case err := <-pl.errs:
+		return nil, err
+	case <-pl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (pl *proxyProtocolListener) Close() error {
+	pl.closeOnce.Do(func() { close(pl.done) })
+	return pl.Listener.Close()
+}
+
+// proxyProtocolConn is a connection whose PROXY protocol header has
+// already been parsed: the remote address is the one found in the header
+// and the reads continue from the data buffered after it
+type proxyProtocolConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readProxyProtocolHeader reads the PROXY protocol header and returns the
+// client address. The address is nil if the header does not carry it (like
+// with the LOCAL command, used by the health checks of the load balancers)
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	if sig, err := r.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(sig, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+
+	if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+		return nil, errMissingProxyHeader
+	}
+
+	return readProxyProtocolV1(r)
+}
+
+// readProxyProtocolV1 parses the human-readable header, like
+// "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, error) {
+	const maxLength = 107
+
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxLength {
+			return nil, errMissingProxyHeader
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errMissingProxyHeader, err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errMissingProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errMissingProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyProtocolV2 parses the binary header
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMissingProxyHeader, err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, errMissingProxyHeader
+	}
+	command := header[12] & 0x0F
+	family := header[13] >> 4
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMissingProxyHeader, err)
+	}
+
+	// LOCAL command: the connection was made by the proxy itself
+	if command == 0 {
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, errMissingProxyHeader
+	}
+
+	switch family {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errMissingProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errMissingProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyProtocolV2Header builds a version 2 header with the given command,
+// family and payload
+func proxyProtocolV2Header(command, family byte, payload []byte) string {
+	header := append([]byte(nil), proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family<<4|1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	ipv4Payload := []byte{203, 0, 113, 5, 10, 0, 0, 1, 0xDB, 0xC4, 0x01, 0xBB}
+	ipv6Payload := make([]byte, 36)
+	copy(ipv6Payload, net.ParseIP("2001:db8::5"))
+	binary.BigEndian.PutUint16(ipv6Payload[32:], 56324)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\nGET", "203.0.113.5:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::5 2001:db8::1 56324 443\r\nGET", "[2001:db8::5]:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\nGET", "", false},
+		{"v1 truncated", "PROXY TCP4 203.0.113.5 10.0.0.1 5632", "", true},
+		{"v1 without CRLF", "PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\nGET", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", true},
+		{"v1 missing fields", "PROXY TCP4 203.0.113.5 10.0.0.1 56324\r\n", "", true},
+		{"v1 invalid protocol", "PROXY UDP4 203.0.113.5 10.0.0.1 56324 443\r\n", "", true},
+		{"v1 invalid address", "PROXY TCP4 203.0.113 10.0.0.1 56324 443\r\n", "", true},
+		{"v1 invalid port", "PROXY TCP4 203.0.113.5 10.0.0.1 70000 443\r\n", "", true},
+		{"v2 TCP4", proxyProtocolV2Header(1, 1, ipv4Payload) + "GET", "203.0.113.5:56260", false},
+		{"v2 TCP6", proxyProtocolV2Header(1, 2, ipv6Payload) + "GET", "[2001:db8::5]:56324", false},
+		{"v2 LOCAL", proxyProtocolV2Header(0, 0, nil) + "GET", "", false},
+		{"v2 unspecified family", proxyProtocolV2Header(1, 0, nil) + "GET", "", false},
+		{"v2 truncated header", proxyProtocolV2Header(1, 1, ipv4Payload)[:14], "", true},
+		{"v2 truncated payload", proxyProtocolV2Header(1, 1, ipv4Payload)[:20], "", true},
+		{"v2 short payload", proxyProtocolV2Header(1, 1, ipv4Payload[:8]), "", true},
+		{"v2 invalid version", strings.Replace(proxyProtocolV2Header(1, 1, ipv4Payload), "\x21", "\x11", 1), "", true},
+		{"v2 invalid command", proxyProtocolV2Header(2, 1, ipv4Payload), "", true},
+		{"missing header", "GET / HTTP/1.1\r\n\r\n", "", true},
+		{"empty connection", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			addr, err := readProxyProtocolHeader(r)
+
+			if tt.wantErr {
+				if !errors.Is(err, errMissingProxyHeader) {
+					t.Fatalf("got error %v, want errMissingProxyHeader", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("got address %q, want %q", got, tt.want)
+			}
+
+			if rest, _ := io.ReadAll(r); string(rest) != "GET" {
+				t.Errorf("got %q after the header, want \"GET\"", rest)
+			}
+		})
+	}
+}
+
+// acceptTimeout accepts a connection from the listener, failing
+// if none is accepted within a second
+func acceptTimeout(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+
+	connC := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		connC <- conn
+	}()
+
+	select {
+	case conn := <-connC:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-time.After(time.Second):
+		t.Fatal("no connection accepted")
+		return nil
+	}
+}
+
+// newProxyProtocolTestListener starts a PROXY protocol
+// listener with the given trusted sources
+func newProxyProtocolTestListener(t *testing.T, trusted ...string) *proxyProtocolListener {
+	t.Helper()
+
+	nets, err := parseTrustedNets(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pl := newProxyProtocolListener(l, nets)
+	t.Cleanup(func() { pl.Close() })
+	return pl
+}
+
+// dialProxyProtocolTest connects to the listener and sends the data
+func dialProxyProtocolTest(t *testing.T, l net.Listener, data string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := io.WriteString(conn, data); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	pl := newProxyProtocolTestListener(t)
+
+	// a client not sending anything must not block the other connections
+	dialProxyProtocolTest(t, pl, "")
+	dialProxyProtocolTest(t, pl, "PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\nhello")
+
+	conn := acceptTimeout(t, pl)
+	if addr := conn.RemoteAddr().String(); addr != "203.0.113.5:56324" {
+		t.Errorf("got remote address %s, want 203.0.113.5:56324", addr)
+	}
+
+	data := make([]byte, 5)
+	if _, err := io.ReadFull(conn, data); err != nil || string(data) != "hello" {
+		t.Errorf("got %q, %v after the header, want \"hello\"", data, err)
+	}
+}
+
+func TestProxyProtocolListenerInvalidHeader(t *testing.T) {
+	pl := newProxyProtocolTestListener(t)
+
+	client := dialProxyProtocolTest(t, pl, "GET / HTTP/1.1\r\n\r\n")
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	var netErr net.Error
+	if _, err := client.Read(make([]byte, 1)); err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		t.Errorf("the connection without header was not closed: %v", err)
+	}
+
+	dialProxyProtocolTest(t, pl, "PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\n")
+	if addr := acceptTimeout(t, pl).RemoteAddr().String(); addr != "203.0.113.5:56324" {
+		t.Errorf("got remote address %s, want 203.0.113.5:56324", addr)
+	}
+}
+
+func TestProxyProtocolListenerUntrustedSource(t *testing.T) {
+	pl := newProxyProtocolTestListener(t, "10.0.0.0/8")
+
+	header := "PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\n"
+	client := dialProxyProtocolTest(t, pl, header)
+
+	conn := acceptTimeout(t, pl)
+	if addr := conn.RemoteAddr().String(); addr != client.LocalAddr().String() {
+		t.Errorf("got remote address %s, want the socket address %s", addr, client.LocalAddr())
+	}
+
+	data := make([]byte, len(header))
+	if _, err := io.ReadFull(conn, data); err != nil || string(data) != header {
+		t.Errorf("got %q, %v, want the header left unparsed", data, err)
+	}
+}
+
+func TestProxyProtocolListenerClose(t *testing.T) {
+	pl := newProxyProtocolTestListener(t)
+
+	errC := make(chan error, 1)
+	go func() {
+		_, err := pl.Accept()
+		errC <- err
+	}()
+	pl.Close()
+
+	select {
+	case err := <-errC:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("got error %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrepForwarded(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "198.51.100.7:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.5", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.com",
+			},
+			want: "198.51.100.7 false example.com",
+		},
+		{
+			name:       "single proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.5", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com",
+			},
+			want: "203.0.113.5 true www.example.com",
+		},
+		{
+			name:       "client spoofing behind an appending proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.2.3.4, 203.0.113.5", "X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "evil.com, example.com",
+			},
+			want: "203.0.113.5 false example.com",
+		},
+		{
+			name:       "two trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "1.2.3.4, 203.0.113.5, 10.0.0.2", "X-Forwarded-Proto": "http, https, http", "X-Forwarded-Host": "evil.com, www.example.com, internal",
+			},
+			want: "203.0.113.5 true www.example.com",
+		},
+		{
+			name:       "proto and host set only by the last proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.5, 10.0.0.2", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com",
+			},
+			want: "203.0.113.5 true www.example.com",
+		},
+		{
+			name:       "Forwarded header",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded": `for=203.0.113.5;proto=https;host=www.example.com`,
+			},
+			want: "203.0.113.5 true www.example.com",
+		},
+		{
+			name:       "Forwarded header with client spoofing",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded": `for=1.2.3.4;proto=https;host=evil.com, for=203.0.113.5;proto=http;host=example.com`,
+			},
+			want: "203.0.113.5 false example.com",
+		},
+		{
+			name:       "Forwarded header with two trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded": `for=203.0.113.5;proto=https;host=www.example.com, for="10.0.0.2:4000";proto=http;host=internal`,
+			},
+			want: "203.0.113.5 true www.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{
+				ServeF: func(route *Route) {
+					route.ServeText(fmt.Sprintf("%s %v %s", route.RemoteAddress, route.Secure, route.Host))
+				},
+			})
+			err := srv.SetProxyConfig(ProxyConfig{
+				TrustedProxies: []string{"10.0.0.0/8"},
+				Forwarded:      true, ForwardedFor: true, ForwardedProto: true, ForwardedHost: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			if got := doTestRequest(srv, req).Body.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryFieldError is the error reported by Route.BindQuery for
+// every field of the struct that could not be populated
+type QueryFieldError struct {
+	Field string // Field is the name of the struct field
+	Query string // Query is the name of the query parameter
+	Err   error  // Err is the underlying error
+}
+
+func (err *QueryFieldError) Error() string {
+	return fmt.Sprintf("query parameter \"%s\" (field %s): %v", err.Query, err.Field, err.Err)
+}
+
+func (err *QueryFieldError) Unwrap() error {
+	return err.Err
+}
+
+// ErrQueryRequired is reported when a required query parameter is missing
+var ErrQueryRequired = errors.New("required parameter is missing")
+
+// BindQuery populates the struct pointed by v with the query parameters of
+// the request. Only the fields with the query tag are populated, the tag must
+// contain the name of the query parameter optionally followed by the "required"
+// option, like so:
+//
+//	type Filter struct {
+//		Search string   `query:"q,required"`
+//		Page   int      `query:"page"`
+//		Tags   []string `query:"tag"`
+//	}
+//
+// Supported field types are strings, booleans, integers, floats, time.Duration,
+// every type implementing encoding.TextUnmarshaler and slices or pointers of them.
+// Slice fields are populated with every value of the query parameter, so a request
+// like "?tag=a&tag=b" is handled correctly.
+//
+// The returned error, if not nil, joins a QueryFieldError for every field that
+// could not be populated, so that every problem can be reported at once
+func (route *Route) BindQuery(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind query: expected a non-nil pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	query := route.R.URL.Query()
+
+	var errs []error
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+
+		tag, ok := field.Tag.Lookup("query")
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		values := query[name]
+		if len(values) == 0 {
+			if opts == "required" {
+				errs = append(errs, &QueryFieldError{field.Name, name, ErrQueryRequired})
+			}
+			continue
+		}
+
+		if err := setQueryField(rv.Field(i), values); err != nil {
+			errs = append(errs, &QueryFieldError{field.Name, name, err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// setQueryField sets the field value from the query values. If the field
+// is not a slice, only the first value is used
+func setQueryField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && !implementsTextUnmarshaler(field) {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setQueryValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+
+		field.Set(slice)
+		return nil
+	}
+
+	return setQueryValue(field, values[0])
+}
+
+func implementsTextUnmarshaler(v reflect.Value) bool {
+	return v.CanAddr() && v.Addr().Type().Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem())
+}
+
+// setQueryValue converts the single value into the type of v and sets it
+func setQueryValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setQueryValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+
+		v.Set(ptr)
+		return nil
+	}
+
+	if implementsTextUnmarshaler(v) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		if value == "" {
+			v.SetBool(true)
+			return nil
+		}
+
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nixpare/logger"
+)
+
+// ReloadErrorTemplates parses again every error template of the server, of its
+// domains and of their subdomains that was loaded from a file (see
+// HTTPServer.SetErrorTemplateFromFile), so that changes on disk are applied
+// without restarting the server. The templates that are not valid anymore keep
+// the previous version and their errors are returned joined together
+func (srv *HTTPServer) ReloadErrorTemplates() error {
+	var errs []error
+	reload := func(name string, path string, set func(t *template.Template)) {
+		if path == "" {
+			return
+		}
+
+		t, err := loadErrorTemplateFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		set(t)
+	}
+
+	reload("server", srv.errTmplPath, func(t *template.Template) { srv.errTemplate.Store(t) })
+	for _, d := range srv.domains {
+		d := d
+		reload(d.Name, d.errTmplPath, func(t *template.Template) { d.errTemplate.Store(t) })
+
+		for _, sd := range d.subdomains {
+			sd := sd
+			reload(sd.Name+d.Name, sd.errTmplPath, func(t *template.Template) { sd.errTemplate.Store(t) })
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	srv.Logger.Printf(logger.LOG_LEVEL_INFO, "Error templates reloaded")
+	return nil
+}
+
+// ReloadContent parses again the templates of the subdomain (see Subdomain.LoadTemplatesFS
+// and Subdomain.WatchAndReload), if any, and deletes every cached response generated by
+// the subdomain (see Route.ServeCachedFunc and Subdomain.ServeFromOrigin), returning how
+// many were deleted. Static files are always read from the disk, so they are not affected.
+// If the templates are not valid anymore, the previous ones are kept and the error is returned
+func (sd *Subdomain) ReloadContent() (purged int, err error) {
+	if sd.tmplFS != nil {
+		if err = sd.LoadTemplatesFS(sd.tmplFS, sd.tmplPatterns...); err != nil {
+			return
+		}
+	}
+
+	if sd.domain != nil {
+		purged = sd.domain.srv.respCache.purge(sd)
+	}
+	return
+}
+
+// PurgeResponseCache deletes every response stored in the server
+// cache (see Route.ServeCachedFunc) and returns how many were deleted
+func (srv *HTTPServer) PurgeResponseCache() int {
+	return srv.respCache.purge(nil)
+}
+
+// ReloadCommand executes a reload command from an administration channel
+// (see Router.ReloadCommandHandler) and returns a report of what was done:
+//   - "reload templates" reloads the error templates of every HTTP server
+//     (see HTTPServer.ReloadErrorTemplates)
+//   - "reload content <host>" reloads the content of the subdomain serving the
+//     host, like "www.example.com" or "example.com:8080" to select only the server
+//     listening on that port (see Subdomain.ReloadContent)
+//
+// The error reports what failed, while the other servers and subdomains are
+// reloaded anyway
+func (router *Router) ReloadCommand(args ...string) (string, error) {
+	if len(args) < 2 || args[0] != "reload" {
+		return "", errors.New("usage: reload templates | reload content <host>")
+	}
+
+	switch {
+	case args[1] == "templates" && len(args) == 2:
+		var errs []error
+		for port, srv := range router.httpServers {
+			if err := srv.ReloadErrorTemplates(); err != nil {
+				errs = append(errs, fmt.Errorf("server %d: %w", port, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("error templates of %d servers reloaded", len(router.httpServers)), nil
+	case args[1] == "content" && len(args) == 3:
+		return router.reloadContent(args[2])
+	default:
+		return "", fmt.Errorf("unknown reload command %q", strings.Join(args[1:], " "))
+	}
+}
+
+// reloadContent reloads the content of the subdomains serving the
+// host in every HTTP server, or only in the one listening on
+// the port, if specified
+func (router *Router) reloadContent(hostport string) (string, error) {
+	port := -1
+	if _, p, err := net.SplitHostPort(hostport); err == nil {
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", fmt.Errorf("invalid port in %q", hostport)
+		}
+	}
+	domainName, subdomainName := parseDomainAndSubdomainNames(hostport)
+
+	var reports []string
+	var errs []error
+	for srvPort, srv := range router.httpServers {
+		if port != -1 && srvPort != port {
+			continue
+		}
+
+		d := srv.domains[domainName]
+		if d == nil {
+			continue
+		}
+		sd := d.subdomains[subdomainName]
+		if sd == nil {
+			continue
+		}
+
+		purged, err := sd.ReloadContent()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %d: %w", srvPort, err))
+			continue
+		}
+		reports = append(reports, fmt.Sprintf("server %d: %d cached responses purged", srvPort, purged))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+	if len(reports) == 0 {
+		return "", fmt.Errorf("no subdomain serving %q", hostport)
+	}
+
+	sort.Strings(reports)
+	return fmt.Sprintf("content of %s reloaded (%s)", hostport, strings.Join(reports, ", ")), nil
+}
+
+// ReloadCommandHandler returns a TCPServer connection handler acting as an administration
+// channel for the reload commands (see Router.ReloadCommand): every message received
+// (see ReadFrame) is a command line, like "reload content www.example.com", and the reply
+// is a message starting with "ok: " followed by the report or with "error: " followed by
+// the error. The handler should be wrapped with RequireAuthToken and listen only locally:
+//
+//	srv, _ := router.NewTCPServer("127.0.0.1", 9000, false)
+//	srv.ConnHandler = server.RequireAuthToken(token, router.ReloadCommandHandler())
+func (router *Router) ReloadCommandHandler() ConnHandlerFunc {
+	return func(srv *TCPServer, conn *Conn) {
+		defer conn.TCPConn.Close()
+
+		for {
+			data, err := ReadFrame(conn.TCPConn)
+			if err != nil {
+				return
+			}
+
+			reply := "ok: "
+			report, err := router.ReloadCommand(strings.Fields(string(data))...)
+			if err != nil {
+				reply = "error: "
+				report = err.Error()
+				srv.Logger.Printf(logger.LOG_LEVEL_WARNING, "Command %q failed: %v", string(data), err)
+			} else {
+				srv.Logger.Printf(logger.LOG_LEVEL_INFO, "Command %q executed: %s", string(data), report)
+			}
+
+			if err := WriteFrame(conn.TCPConn, []byte(reply+report)); err != nil {
+				return
+			}
+		}
+	}
+}
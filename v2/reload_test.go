@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nixpare/logger"
+)
+
+func TestReloadCommandTemplates(t *testing.T) {
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			route.Error(http.StatusNotFound, "Missing page")
+		},
+	})
+
+	path := t.TempDir() + "/error.html"
+	if err := os.WriteFile(path, []byte("<h1>v1 {{ .Code }} {{ .Message }}</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetErrorTemplateFromFile(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("<h1>v2 {{ .Code }} {{ .Message }}</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Router.ReloadCommand("reload", "templates"); err != nil {
+		t.Fatalf("reload templates: %v", err)
+	}
+	if body := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil)).Body.String(); body != "<h1>v2 404 Missing page</h1>" {
+		t.Errorf("got body %q after reload", body)
+	}
+
+	if err := os.WriteFile(path, []byte("<h1>no fields</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Router.ReloadCommand("reload", "templates"); err == nil {
+		t.Error("reload with an invalid template succeeded")
+	}
+	if body := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil)).Body.String(); body != "<h1>v2 404 Missing page</h1>" {
+		t.Errorf("got body %q, want the previous template", body)
+	}
+}
+
+func TestReloadCommandContent(t *testing.T) {
+	calls := 0
+	srv := newTestServer(t, SubdomainConfig{})
+	srv.RegisterDomain("Example", "example.com").RegisterSubdomain("www", SubdomainConfig{
+		Website: Website{Name: "Example", Dir: t.TempDir()},
+		ServeF: func(route *Route) {
+			route.ServeCachedFunc("page", time.Hour, func() ([]byte, string, error) {
+				calls++
+				return []byte("page"), "text/plain", nil
+			})
+		},
+	})
+
+	request := func() {
+		req := httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+		if rec := doTestRequest(srv, req); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d", rec.Code)
+		}
+	}
+
+	request()
+	request()
+	report, err := srv.Router.ReloadCommand("reload", "content", "www.example.com")
+	if err != nil {
+		t.Fatalf("reload content: %v", err)
+	}
+	if !strings.Contains(report, "1 cached responses purged") {
+		t.Errorf("unexpected report %q", report)
+	}
+	request()
+
+	if calls != 2 {
+		t.Errorf("generated %d times, want 2", calls)
+	}
+
+	for _, args := range [][]string{
+		{"reload", "content", "missing.example.com"},
+		{"reload", "content"},
+		{"reload", "everything"},
+		{"restart"},
+	} {
+		if _, err := srv.Router.ReloadCommand(args...); err == nil {
+			t.Errorf("command %q succeeded", strings.Join(args, " "))
+		}
+	}
+}
+
+func TestReloadCommandHandler(t *testing.T) {
+	srv := newTestServer(t, SubdomainConfig{})
+	tcpSrv := &TCPServer{Logger: logger.DefaultLogger}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go srv.Router.ReloadCommandHandler()(tcpSrv, &Conn{TCPConn: conn})
+
+	for cmd, want := range map[string]string{
+		"reload templates":  "ok: ",
+		"reload everything": "error: ",
+	} {
+		if err := WriteFrame(client, []byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := ReadFrame(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(reply), want) {
+			t.Errorf("%q: got reply %q, want prefix %q", cmd, reply, want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultResponseCacheSize is the default maximum amount of memory, in
+// bytes, used by the response cache of every server (see Route.ServeCachedFunc)
+var DefaultResponseCacheSize int64 = 32 << 20
+
+// cachedResponse is a response generated by Route.ServeCachedFunc
+type cachedResponse struct {
+	key         string
+	owner       *Subdomain
+	data        []byte
+	contentType string
+	etag        string
+	created     time.Time
+	expires     time.Time
+}
+
+// cacheCall is a response generation in progress, waited by
+// every concurrent request with the same key
+type cacheCall struct {
+	done chan struct{}
+	resp *cachedResponse
+	err  error
+}
+
+// responseCache is an in-memory LRU cache of generated responses,
+// bounded by the total size of the bodies
+type responseCache struct {
+	m        sync.Mutex
+	maxSize  int64
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	inFlight map[string]*cacheCall
+}
+
+func newResponseCache(maxSize int64) *responseCache {
+	return &responseCache{
+		maxSize:  maxSize,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inFlight: make(map[string]*cacheCall),
+	}
+}
+
+// get returns the cached response with the given key, if not expired, otherwise
+// calls the function to generate it. Concurrent calls with the same key wait for
+// the first one to generate the response. The owner is the subdomain generating
+// the response, so that its responses can be purged (see Subdomain.ReloadContent)
+func (c *responseCache) get(key string, owner *Subdomain, ttl time.Duration, f func() ([]byte, string, error)) (*cachedResponse, error) {
+	return c.getWithTTL(key, owner, func() ([]byte, string, time.Duration, error) {
+		data, contentType, err := f()
+		return data, contentType, ttl, err
+	})
+}
+
+// getWithTTL is like get, but the ttl is returned by the function: if
+// it's zero or less, the response is returned but not stored. If the cache
+// is disabled (see HTTPServer.SetResponseCacheSize), the function is
+// always called, without waiting for the concurrent calls
+func (c *responseCache) getWithTTL(key string, owner *Subdomain, f func() ([]byte, string, time.Duration, error)) (*cachedResponse, error) {
+	c.m.Lock()
+	if c.maxSize <= 0 {
+		c.m.Unlock()
+
+		data, contentType, _, err := f()
+		if err != nil {
+			return nil, err
+		}
+		return newCachedResponse(key, owner, data, contentType, 0), nil
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		resp := elem.Value.(*cachedResponse)
+		if time.Now().Before(resp.expires) {
+			c.lru.MoveToFront(elem)
+			c.m.Unlock()
+			return resp, nil
+		}
+		c.remove(elem)
+	}
+
+	if call, ok := c.inFlight[key]; ok {
+		c.m.Unlock()
+		<-call.done
+		return call.resp, call.err
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.inFlight[key] = call
+	c.m.Unlock()
+
+	defer func() {
+		c.m.Lock()
+		delete(c.inFlight, key)
+		c.m.Unlock()
+		close(call.done)
+	}()
+
+	call.err = fmt.Errorf("panic while generating the response")
+	data, contentType, ttl, err := f()
+	if err != nil {
+		call.err = err
+		return nil, err
+	}
+
+	call.resp = newCachedResponse(key, owner, data, contentType, ttl)
+	call.err = nil
+
+	if ttl > 0 {
+		c.m.Lock()
+		c.add(call.resp)
+		c.m.Unlock()
+	}
+
+	return call.resp, nil
+}
+
+// newCachedResponse creates the response, generating its ETag
+func newCachedResponse(key string, owner *Subdomain, data []byte, contentType string, ttl time.Duration) *cachedResponse {
+	now := time.Now()
+	return &cachedResponse{
+		key:         key,
+		owner:       owner,
+		data:        data,
+		contentType: contentType,
+		etag:        fmt.Sprintf("\"%s\"", GenerateHashString(data)[:32]),
+		created:     now,
+		expires:     now.Add(ttl),
+	}
+}
+
+// add stores the response, evicting the least recently used ones
+// if the maximum size is exceeded. The responses bigger than
+// the cache are not stored. It must be called with the lock held
+func (c *responseCache) add(resp *cachedResponse) {
+	size := int64(len(resp.data))
+	if c.maxSize <= 0 || size > c.maxSize {
+		return
+	}
+
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	c.size += size
+}
+
+// remove deletes the element from the cache.
+// It must be called with the lock held
+func (c *responseCache) remove(elem *list.Element) {
+	resp := c.lru.Remove(elem).(*cachedResponse)
+	delete(c.entries, resp.key)
+	c.size -= int64(len(resp.data))
+}
+
+// resize changes the maximum size of the cache, evicting
+// the responses exceeding the new size
+func (c *responseCache) resize(maxSize int64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.maxSize = maxSize
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// maxEntrySize returns the size of the biggest response that can be stored,
+// that is the size of the cache or DefaultResponseCacheSize if it's disabled
+func (c *responseCache) maxEntrySize() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.maxSize <= 0 {
+		return DefaultResponseCacheSize
+	}
+	return c.maxSize
+}
+
+// purge deletes every response generated by the owner subdomain,
+// or every response if owner is nil, and returns how many were deleted
+func (c *responseCache) purge(owner *Subdomain) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var n int
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if owner == nil || elem.Value.(*cachedResponse).owner == owner {
+			c.remove(elem)
+			n++
+		}
+		elem = next
+	}
+
+	return n
+}
+
+// SetResponseCacheSize sets the maximum amount of memory, in bytes, used to store the
+// responses of Route.ServeCachedFunc: when exceeded, the least recently used responses
+// are evicted. A value <= 0 disables the cache. See DefaultResponseCacheSize
+func (srv *HTTPServer) SetResponseCacheSize(size int64) *HTTPServer {
+	if size < 0 {
+		size = 0
+	}
+
+	srv.respCache.resize(size)
+	return srv
+}
+
+// ResponseCacheUsage returns the amount of memory, in bytes, used to store
+// the responses of Route.ServeCachedFunc and how many responses are stored
+func (srv *HTTPServer) ResponseCacheUsage() (size int64, entries int) {
+	srv.respCache.m.Lock()
+	defer srv.respCache.m.Unlock()
+
+	return srv.respCache.size, len(srv.respCache.entries)
+}
+
+// ServeCachedFunc serves the response generated by the function, which returns the
+// body and its content type (if empty, it's derived from the body), caching it
+// in memory with the given key for the ttl duration. Until the response expires,
+// the function is not called again and concurrent requests with the same key
+// wait for a single generation. The key is shared by the whole server, so it's
+// usually derived from the request URL (like route.Host + route.RequestURI).
+//
+// The responses are evicted when the server cache is full (see HTTPServer.SetResponseCacheSize)
+// and the errors are never cached: if the function fails, an Internal Server Error is reported.
+// An ETag is generated from the body, so that conditional requests are handled automatically
+func (route *Route) ServeCachedFunc(key string, ttl time.Duration, f func() ([]byte, string, error)) {
+	resp, err := route.Srv.respCache.get(key, route.Subdomain, ttl, f)
+	if err != nil {
+		route.Error(http.StatusInternalServerError, "Internal server error", "Error generating cached response", key+":", err)
+		return
+	}
+
+	route.serveCachedResponse(resp)
+}
+
+// serveCachedResponse serves the response stored in
+// the cache, handling the conditional requests
+func (route *Route) serveCachedResponse(resp *cachedResponse) {
+	if resp.contentType != "" {
+		route.W.Header().Set("Content-Type", resp.contentType)
+	}
+	route.W.Header().Set("ETag", resp.etag)
+
+	route.serveContent("", resp.created, bytes.NewReader(resp.data))
+}
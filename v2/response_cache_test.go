@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServeCachedFuncDefaultServer(t *testing.T) {
+	calls := 0
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			route.ServeCachedFunc("test", time.Minute, func() ([]byte, string, error) {
+				calls++
+				return []byte("cached body"), "text/plain", nil
+			})
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "cached body" {
+			t.Fatalf("request %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("generated %d times, want 1", calls)
+	}
+	if _, entries := srv.ResponseCacheUsage(); entries != 1 {
+		t.Errorf("cache has %d entries, want 1", entries)
+	}
+}
+
+func TestResponseCacheDisabled(t *testing.T) {
+	calls := 0
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			route.ServeCachedFunc(route.RequestURI, time.Minute, func() ([]byte, string, error) {
+				calls++
+				if route.RequestURI == "/empty" {
+					return []byte{}, "text/plain", nil
+				}
+				return []byte("body"), "text/plain", nil
+			})
+		},
+	})
+	srv.SetResponseCacheSize(0)
+
+	for _, uri := range []string{"/", "/", "/empty", "/empty"} {
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, uri, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d", uri, rec.Code)
+		}
+	}
+
+	if calls != 4 {
+		t.Errorf("generated %d times, want 4", calls)
+	}
+	if size, entries := srv.ResponseCacheUsage(); size != 0 || entries != 0 {
+		t.Errorf("cache usage is %d bytes in %d entries, want empty", size, entries)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// rewriteRule is a rule added with HTTPServer.AddRewriteRule
+type rewriteRule struct {
+	match        *regexp.Regexp
+	replacement  string
+	redirectCode int
+}
+
+// URLRewriter is a function that can modify the path and the query of the
+// request URL before the routing, see HTTPServer.SetURLRewriter
+type URLRewriter func(u *url.URL)
+
+// AddRewriteRule adds a rule that rewrites the request URI (the escaped path
+// followed by the query, like "/old/page?id=1") before the domain, subdomain and path
+// routing: if the URI matches the regular expression, it's replaced with the replacement,
+// which can contain the capture groups like $1 (see regexp.Regexp.ReplaceAllString).
+//
+// If the redirect code is 0, the rewrite is internal: the request is handled as if the
+// client requested the new URI, without it knowing. Otherwise the client is redirected
+// to the new URI with the given status code (like 301 Moved Permanently or 302 Found).
+// The rules are applied in order, after the rewriter function (see HTTPServer.SetURLRewriter),
+// each one on the result of the previous ones, and the first redirect stops the rewriting
+func (srv *HTTPServer) AddRewriteRule(match, replacement string, redirectCode int) error {
+	re, err := regexp.Compile(match)
+	if err != nil {
+		return fmt.Errorf("invalid rewrite rule: %w", err)
+	}
+
+	if redirectCode != 0 && (redirectCode < 300 || redirectCode > 399) {
+		return fmt.Errorf("invalid rewrite rule redirect code %d", redirectCode)
+	}
+
+	srv.rewriteRules = append(srv.rewriteRules, rewriteRule{
+		match:        re,
+		replacement:  replacement,
+		redirectCode: redirectCode,
+	})
+	return nil
+}
+
+// SetURLRewriter sets a function that can internally rewrite the path and the
+// query of every request URL before the routing, like lowercasing the path or removing
+// the tracking parameters. The changes to the other fields of the URL are ignored.
+// It's executed before the rewrite rules, see HTTPServer.AddRewriteRule
+func (srv *HTTPServer) SetURLRewriter(f URLRewriter) *HTTPServer {
+	srv.urlRewriter = f
+	return srv
+}
+
+// rewriteURI applies the URL rewriter and the rewrite rules of the server to the
+// request URI. The internal rewrites update the request, while a redirect
+// is saved in the route and served later
+func (route *Route) rewriteURI() {
+	if route.Srv.urlRewriter == nil && len(route.Srv.rewriteRules) == 0 {
+		return
+	}
+
+	u := *route.R.URL
+	if route.Srv.urlRewriter != nil {
+		route.Srv.urlRewriter(&u)
+	}
+	uri := u.RequestURI()
+
+	for _, rule := range route.Srv.rewriteRules {
+		if !rule.match.MatchString(uri) {
+			continue
+		}
+
+		uri = rule.match.ReplaceAllString(uri, rule.replacement)
+		if rule.redirectCode != 0 {
+			route.rewriteRedirect = uri
+			route.rewriteRedirectCode = rule.redirectCode
+			return
+		}
+	}
+
+	if uri == route.R.URL.RequestURI() {
+		return
+	}
+
+	newURL, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return
+	}
+
+	route.R.URL.Path = newURL.Path
+	route.R.URL.RawPath = newURL.RawPath
+	route.R.URL.RawQuery = newURL.RawQuery
+	route.R.RequestURI = uri
+	route.RequestURI = uri
+}
+
+// redirectRewrite redirects the client if a rewrite rule requires
+// it (see HTTPServer.AddRewriteRule) and reports whether it was done
+func (route *Route) redirectRewrite() bool {
+	if route.rewriteRedirect == "" {
+		return false
+	}
+
+	http.Redirect(route.W, route.R, route.rewriteRedirect, route.rewriteRedirectCode)
+	return true
+}
@@ -1,7 +1,11 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
@@ -30,6 +34,12 @@ type Router struct {
 	IsInternalConn func(remoteAddress string) bool
 	TaskMgr        *TaskManager
 	Logger         *logger.Logger
+	proxyConfig    *proxyConfig
+	pprofSrv       *http.Server
+	upgraded       bool
+	middlewares    []Middleware
+	upstreamsM     sync.RWMutex
+	upstreams      map[string]string
 }
 
 // NewRouter returns a new Router ready to be set up. If routerPath is not provided,
@@ -62,12 +72,32 @@ func NewRouter(routerPath string) (router *Router, err error) {
 	return
 }
 
+// SetLogOutputs sets the destinations where the logs of the router, of every server
+// registered and of the TaskManager are written: every log is written to all of the
+// outputs (like a log file, os.Stdout and a custom sink), see io.MultiWriter. The
+// logs are still stored by the logger like before. This should be called before
+// starting the router; the servers registered later inherit the outputs automatically
+func (router *Router) SetLogOutputs(outputs ...io.Writer) {
+	router.Logger = router.Logger.Clone(io.MultiWriter(outputs...))
+
+	for port, srv := range router.httpServers {
+		srv.Logger = router.Logger.Clone(nil, "server", "http", fmt.Sprint(port))
+	}
+	for port, srv := range router.tcpServers {
+		srv.Logger = router.Logger.Clone(nil, "server", "tcp", fmt.Sprint(port))
+	}
+	router.TaskMgr.Logger = router.Logger.Clone(nil, "tasks")
+}
+
 // NewServer creates a new HTTP/HTTPS Server linked to the Router. See NewServer function
-// for more information
+// for more information. Only one HTTP server with port 0 (chosen by the system) can be
+// registered, because its actual port is known only after it's started
 func (router *Router) NewHTTPServer(address string, port int, secure bool, path string, certs ...Certificate) (*HTTPServer, error) {
-	_, ok := router.httpServers[port]
-	if ok {
-		return nil, fmt.Errorf("http server listening to port %d already registered", port)
+	if err := router.checkPortFree(port); err != nil {
+		return nil, err
+	}
+	if _, ok := router.httpServers[0]; ok && port == 0 {
+		return nil, errors.New("http server listening to a port chosen by the system (0) already registered")
 	}
 
 	if path == "" {
@@ -81,6 +111,7 @@ func (router *Router) NewHTTPServer(address string, port int, secure bool, path
 
 	router.httpServers[srv.port] = srv
 	srv.Router = router
+	srv.setHandler()
 
 	srv.Logger = router.Logger.Clone(nil, "server", "http", fmt.Sprint(port))
 
@@ -88,11 +119,11 @@ func (router *Router) NewHTTPServer(address string, port int, secure bool, path
 }
 
 // NewServer creates a new TCP Server linked to the Router. See NewTCPServer function
-// for more information
+// for more information. The TCP server binds its port on creation, so a server with
+// port 0 (chosen by the system) is registered with its actual port
 func (router *Router) NewTCPServer(address string, port int, secure bool, certs ...Certificate) (*TCPServer, error) {
-	_, ok := router.tcpServers[port]
-	if ok {
-		return nil, fmt.Errorf("tcp server listening to port %d already registered", port)
+	if err := router.checkPortFree(port); err != nil {
+		return nil, err
 	}
 
 	srv, err := NewTCPServer(address, port, secure, certs...)
@@ -103,15 +134,60 @@ func (router *Router) NewTCPServer(address string, port int, secure bool, certs
 	router.tcpServers[srv.port] = srv
 	srv.Router = router
 
-	srv.Logger = router.Logger.Clone(nil, "server", "tcp", fmt.Sprint(port))
+	srv.Logger = router.Logger.Clone(nil, "server", "tcp", fmt.Sprint(srv.port))
 
 	return srv, nil
 }
 
-// Start starts all the registered servers and the background task manager
-func (router *Router) Start() {
+// checkPortFree returns an error if an HTTP or TCP server is already
+// registered on the given port. Port 0 (chosen by the system) is always free
+func (router *Router) checkPortFree(port int) error {
+	if port == 0 {
+		return nil
+	}
+
+	if _, ok := router.httpServers[port]; ok {
+		return fmt.Errorf("http server listening to port %d already registered", port)
+	}
+	if _, ok := router.tcpServers[port]; ok {
+		return fmt.Errorf("tcp server listening to port %d already registered", port)
+	}
+	return nil
+}
+
+// Validate checks that the registered servers can be started: every HTTP server
+// port must not be already in use by another process. The TCP servers bind their
+// port on creation, so they are already checked. This is called by Router.Start,
+// but can be called before to report the error in a custom way
+func (router *Router) Validate() error {
+	var errs []error
+	for port, srv := range router.httpServers {
+		if port == 0 || srv.IsRunning() || hasInheritedListener(srv.Server.Addr) {
+			continue
+		}
+
+		l, err := net.Listen("tcp", srv.Server.Addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("http server port %d is not available: %w", port, err))
+			continue
+		}
+		l.Close()
+	}
+
+	return errors.Join(errs...)
+}
+
+// Start starts all the registered servers and the background task manager.
+// If the servers can't be started (see Router.Validate), the error is
+// logged and returned and nothing is started
+func (router *Router) Start() error {
 	if router.state.AlreadyStarted() {
-		return
+		return nil
+	}
+
+	if err := router.Validate(); err != nil {
+		router.Logger.Printf(logger.LOG_LEVEL_FATAL, "Router startup aborted: %v", err)
+		return fmt.Errorf("router startup aborted: %w", err)
 	}
 	router.state.SetState(LCS_STARTING)
 
@@ -131,6 +207,7 @@ func (router *Router) Start() {
 	router.TaskMgr.start()
 
 	router.state.SetState(LCS_STARTED)
+	return nil
 }
 
 // Stop starts the shutdown procedure of the entire router with all
@@ -151,20 +228,62 @@ func (router *Router) Stop() {
 	for _, srv := range router.httpServers {
 		srv.Stop()
 	}
+	router.DisablePprof()
 
-	err := os.Remove(router.Path + "/PID.txt")
-	if err != nil {
-		router.Logger.Printf(logger.LOG_LEVEL_ERROR, "error deleting PID file: %v", err)
+	if !router.upgraded {
+		err := os.Remove(router.Path + "/PID.txt")
+		if err != nil {
+			router.Logger.Printf(logger.LOG_LEVEL_ERROR, "error deleting PID file: %v", err)
+		}
 	}
 
 	router.writeLogClosure(time.Now())
 	router.state.SetState(LCS_STOPPED)
 }
 
+// Restart stops and starts again every server registered, closing and
+// reopening their listeners and running the subdomains cleanup and
+// initialization functions, but without stopping the TaskManager: the
+// registered tasks and processes and their state are left untouched.
+// The HTTP servers wait at most the given timeout for the active connections
+// (see HTTPServer.Restart). This differs from calling Router.Stop and
+// Router.Start, which also stop and start the tasks and processes
+func (router *Router) Restart(timeout time.Duration) {
+	if !router.IsRunning() {
+		return
+	}
+
+	router.Logger.Print(logger.LOG_LEVEL_INFO, "Router servers restart started")
+
+	for _, srv := range router.httpServers {
+		srv.Restart(timeout)
+	}
+	for _, srv := range router.tcpServers {
+		if err := srv.Restart(); err != nil {
+			router.Logger.Printf(logger.LOG_LEVEL_ERROR, "Error restarting tcp server on port %d: %v", srv.port, err)
+		}
+	}
+
+	router.Logger.Print(logger.LOG_LEVEL_INFO, "Router servers restart finished")
+}
+
 func (router *Router) IsRunning() bool {
 	return router.state.GetState() == LCS_STARTED
 }
 
+// RestartHTTPServer gracefully restarts the HTTP server listening on the given port,
+// without affecting the other servers. See HTTPServer.Restart for more information
+func (router *Router) RestartHTTPServer(port int, timeout time.Duration) error {
+	srv := router.httpServers[port]
+	if srv == nil {
+		return fmt.Errorf("http server listening to port %d not found", port)
+	}
+
+	router.Logger.Printf(logger.LOG_LEVEL_INFO, "Restarting http server on port %d", port)
+	srv.Restart(timeout)
+	return nil
+}
+
 // Server returns the HTTP server running on the given port
 func (router *Router) HTTPServer(port int) *HTTPServer {
 	return router.httpServers[port]
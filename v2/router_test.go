@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRouterPortZero(t *testing.T) {
+	router, err := NewRouter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := router.NewHTTPServer("127.0.0.1", 0, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.NewHTTPServer("127.0.0.1", 0, false, ""); err == nil {
+		t.Error("a second http server with port 0 was registered")
+	}
+
+	var tcpServers []*TCPServer
+	for i := 0; i < 2; i++ {
+		srv, err := router.NewTCPServer("127.0.0.1", 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { srv.listener.Close() })
+		tcpServers = append(tcpServers, srv)
+	}
+
+	if len(router.tcpServers) != 2 {
+		t.Fatalf("got %d tcp servers registered, want 2", len(router.tcpServers))
+	}
+	for _, srv := range tcpServers {
+		if srv.Port() == 0 {
+			t.Fatal("the tcp server has no port")
+		}
+		if router.TCPServer(srv.Port()) != srv {
+			t.Errorf("the tcp server is not registered with its port %d", srv.Port())
+		}
+	}
+
+	port := tcpServers[0].Port()
+	if _, err := router.NewTCPServer("127.0.0.1", port, false); err == nil {
+		t.Errorf("a tcp server was registered on the port %d already in use", port)
+	}
+}
+
+func TestRouterStartValidation(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	router, err := NewRouter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := router.NewHTTPServer("127.0.0.1", l.Addr().(*net.TCPAddr).Port, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.Start(); err == nil {
+		router.Stop()
+		t.Fatal("the router started with a port already in use")
+	}
+	if router.IsRunning() {
+		t.Error("the router is running after a failed start")
+	}
+}
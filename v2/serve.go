@@ -2,16 +2,29 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/nixpare/logger"
@@ -47,6 +60,19 @@ func (route *Route) Error(statusCode int, message string, a ...any) {
 	}
 }
 
+// ErrorWithHeaders is like Route.Error, but first sets the given headers in
+// the response, like Retry-After for 429 Too Many Requests and 503 Service
+// Unavailable or WWW-Authenticate for 401 Unauthorized. The error is then served
+// in the usual way (with the error template, if any). It must be called before
+// writing anything else, otherwise the headers are already sent
+func (route *Route) ErrorWithHeaders(statusCode int, message string, headers map[string]string, a ...any) {
+	for key, value := range headers {
+		route.W.Header().Set(key, value)
+	}
+
+	route.Error(statusCode, message, a...)
+}
+
 // Errorf is like the method Route.Error but you can format the output
 // to the Log. Like the Route.Logf, everything that is after the first
 // line feed will be used to populate the extra field of the Log
@@ -54,6 +80,31 @@ func (route *Route) Errorf(statusCode int, message string, format string, a ...a
 	route.Error(statusCode, message, fmt.Sprintf(format, a...))
 }
 
+// routeAbort is the value used by Route.Must to abort the serve function
+type routeAbort struct {
+	clientMsg string
+	err       error
+}
+
+// Must aborts the serve function if the error is not nil, reporting an Internal
+// Server Error with the given message for the client (like Route.Error) and
+// the error in the logs. This avoids repeating the error checks after every
+// fallible step of a serve function:
+//
+//	data, err := os.ReadFile(path)
+//	route.Must(err, "Could not load the page")
+//
+// The serve function is stopped with a controlled panic, recovered by the server,
+// so the deferred functions are still executed, but it must not be called outside
+// of the goroutine running the serve function
+func (route *Route) Must(err error, clientMsg string) {
+	if err == nil {
+		return
+	}
+
+	panic(routeAbort{clientMsg: clientMsg, err: err})
+}
+
 // ServeFile will serve a file in the file system. If the path is not
 // absolute, it will first try to complete it with the website directory
 // (if set) or with the server path
@@ -62,8 +113,9 @@ func (route *Route) ServeFile(filePath string) {
 		filePath = route.Website.Dir + "/" + filePath
 	}
 
-	if strings.Contains(filePath, "..") {
-		route.Error(http.StatusBadRequest, "Bad request URL", "URL contains ..")
+	filePath, err := route.Website.cleanPath(filePath)
+	if err != nil {
+		route.Error(http.StatusBadRequest, "Bad request URL", err)
 		return
 	}
 
@@ -84,7 +136,7 @@ func (route *Route) ServeFile(filePath string) {
 			return
 		}
 
-		http.ServeFile(route.W, route.R, filePath)
+		route.serveFile(filePath)
 		return
 	}
 
@@ -97,10 +149,98 @@ func (route *Route) ServeFile(filePath string) {
 		}
 	}
 
+	route.serveFile(filePath)
+}
+
+// serveFile serves the already cleaned file path, checking first that
+// it does not escape the Website.Dir through symbolic links, if this
+// behaviour was disabled in the Website (see Website.NoSymlinkEscape)
+func (route *Route) serveFile(filePath string) {
+	if err := route.Website.checkSymlinks(filePath); err != nil {
+		route.Error(http.StatusNotFound, "Not found", err)
+		return
+	}
+
+	route.prepareCompression()
 	http.ServeFile(route.W, route.R, filePath)
 }
 
+// ServeFileInline serves the file like Route.ServeFile, but also sets the
+// Content-Disposition header to "inline" with the suggested file name, so
+// that browsers will show a preview of the file (like PDFs and images) and
+// use that name when the user saves it. If suggestedName is empty, the base name
+// of the file is used. A Content-Type header already set is not overridden
+func (route *Route) ServeFileInline(filePath string, suggestedName string) {
+	if suggestedName == "" {
+		suggestedName = filepath.Base(filePath)
+	}
+
+	route.W.Header().Set("Content-Disposition", contentDisposition("inline", suggestedName))
+	route.ServeFile(filePath)
+}
+
+// contentDisposition builds the value of a Content-Disposition header with the
+// given type and file name. Names with non-ASCII characters are encoded following
+// RFC 5987 in the "filename*" parameter, with an ASCII fallback for older clients
+func contentDisposition(dispType string, fileName string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range fileName {
+		switch {
+		case r >= utf8.RuneSelf:
+			ascii = false
+			fallback.WriteByte('_')
+		case r < ' ' || r == 0x7f:
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := fmt.Sprintf("%s; filename=\"%s\"", dispType, fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + rfc5987Escape(fileName)
+	}
+
+	return value
+}
+
+// rfc5987Escape percent-encodes every byte of the string
+// that is not an RFC 5987 attr-char
+func rfc5987Escape(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) != -1 {
+			sb.WriteByte(c)
+			continue
+		}
+
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&0xf])
+	}
+
+	return sb.String()
+}
+
 func (route *Route) serveXFile(xFilePath string) {
+	info, err := os.Stat(xFilePath)
+	if err != nil {
+		route.Error(http.StatusNotFound, "Not found", "XFile target not found:", err)
+		return
+	}
+	if info.IsDir() {
+		route.Error(http.StatusInternalServerError, "Internal server error", "XFile target", xFilePath, "is a directory")
+		return
+	}
+
 	x, err := NewXFile(xFilePath)
 	if err != nil {
 		route.Error(
@@ -111,19 +251,243 @@ func (route *Route) serveXFile(xFilePath string) {
 		return
 	}
 
-	http.ServeContent(route.W, route.R, route.RequestURI, x.ModTime(), x)
+	route.prepareCompression()
+	route.serveContent(route.RequestURI, x.ModTime(), x)
+}
+
+// Context returns the context of the request. The context is canceled when
+// the client's connection closes, the request is canceled (with HTTP/2) or
+// when the server is forcibly shut down, so long running serve functions
+// can use it to stop their work when the client has gone away
+func (route *Route) Context() context.Context {
+	return route.R.Context()
+}
+
+// Done is a shorthand for route.Context().Done(): the returned channel
+// is closed when the request context is canceled (see Route.Context)
+func (route *Route) Done() <-chan struct{} {
+	return route.R.Context().Done()
+}
+
+// AddVary adds the field to the Vary header of the response, only if not
+// already present (the comparison is case-insensitive). If the Vary header
+// is set to "*", nothing is added
+func (route *Route) AddVary(field string) {
+	header := route.W.Header()
+
+	var fields []string
+	for _, value := range header.Values("Vary") {
+		fields = append(fields, splitHeaderList(value)...)
+	}
+
+	for _, f := range fields {
+		if f == "*" || strings.EqualFold(f, field) {
+			return
+		}
+	}
+
+	header.Set("Vary", strings.Join(append(fields, field), ", "))
+}
+
+// DisableRanges makes the serving functions (like Route.ServeFile and
+// Route.ServeCustomFile) ignore the Range header of the request, always serving
+// the full content with 200 OK, and sets the Accept-Ranges header to "none".
+// This is useful for generated content or when every download must be counted.
+// By default the ranges are supported and the Accept-Ranges header is "bytes"
+// on the responses of these functions, unless the content is compressed
+func (route *Route) DisableRanges() {
+	route.R.Header.Del("Range")
+	route.R.Header.Del("If-Range")
+	route.W.noRanges = true
+}
+
+// StripHeaders removes the given headers from the response right before
+// they are sent, even if they are set later by the serve function or by
+// an upstream server (see Route.ReverseProxy). This can be used to remove
+// internal or debugging headers
+func (route *Route) StripHeaders(names ...string) {
+	for _, name := range names {
+		route.W.stripHeaders = append(route.W.stripHeaders, http.CanonicalHeaderKey(name))
+	}
+}
+
+// HeaderAllowlist removes from the response every header not in the given
+// list right before they are sent, like Route.StripHeaders. The headers needed
+// to read the body (like Content-Length and Transfer-Encoding) are always kept.
+// Bear in mind that the standard library could still add the Date and the
+// Content-Type headers, if not present. Calling it multiple times extends the list
+func (route *Route) HeaderAllowlist(names ...string) {
+	if route.W.headerAllowlist == nil {
+		route.W.headerAllowlist = make(map[string]bool)
+	}
+
+	for _, name := range names {
+		route.W.headerAllowlist[http.CanonicalHeaderKey(name)] = true
+	}
+}
+
+// Timing starts measuring a phase of the request with the given name (that
+// must be a valid token, like "db" or "render") and returns the function that
+// stops it: the recorded durations are sent to the client in the Server-Timing
+// header, so they can be inspected with the browser developer tools. Only the
+// phases stopped before the response headers are sent are reported. Example:
+//
+//	stop := route.Timing("db")
+//	users := loadUsers()
+//	stop()
+func (route *Route) Timing(name string) func() {
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		route.W.timings = append(route.W.timings, fmt.Sprintf("%s;dur=%.3f", name, float64(d.Microseconds())/1000))
+	}
+}
+
+// SetContentLength sets the Content-Length header of the response to the given
+// number of bytes, so that the response is not sent with the chunked encoding
+// and the client can show the download progress. It must be called before writing
+// the body: if the bytes written by the serve function don't match, the mismatch
+// is logged (writing more bytes than declared fails with http.ErrContentLength)
+func (route *Route) SetContentLength(n int64) {
+	route.W.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	route.W.declaredLength = n
+	route.W.lengthDeclared = true
+}
+
+// checkContentLength logs a warning if the bytes written don't match
+// the length declared with Route.SetContentLength
+func (route *Route) checkContentLength() {
+	w := route.W
+	if !w.lengthDeclared || w.written == w.declaredLength || w.compressing || route.Method == http.MethodHead {
+		return
+	}
+	if w.code == http.StatusNotModified || w.code == http.StatusNoContent || w.code >= 400 {
+		return
+	}
+
+	route.Logger.Printf(logger.LOG_LEVEL_WARNING,
+		"Content-Length mismatch for %s: declared %d bytes, written %d",
+		route.logRequestURI, w.declaredLength, w.written,
+	)
+}
+
+// Push initiates an HTTP/2 server push of the given target (an absolute path
+// or a URL with the same host), see http.Pusher. If the connection does not
+// support server push (like HTTP/1.x connections or clients that disabled it),
+// http.ErrNotSupported is returned. It should be called before writing the response.
+//
+// Server push has been removed from most browsers, which ignore the pushed
+// resources, and it wastes bandwidth if the client already cached them: consider
+// using Route.EarlyHints instead. See also Website.PushAssets
+func (route *Route) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := route.W.w.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+
+	return pusher.Push(target, opts)
+}
+
+// EarlyHints sends a 103 Early Hints informational response with a
+// Link: rel=preload header for each of the given links, so that the client
+// can start fetching the critical resources (like CSS and JS files) while the
+// final response is being prepared. A link can be a plain URL, in which case
+// the "as" attribute is guessed from the extension, or a full Link header
+// value like "</font.woff2>; rel=preload; as=font; crossorigin".
+// The Link headers are kept in the final response too.
+//
+// It must be called before writing the response and it's a no-op if the
+// response was already started or the client does not support
+// informational responses (HTTP/1.0 clients)
+func (route *Route) EarlyHints(links ...string) {
+	if len(links) == 0 || route.W.code != 0 || route.W.hasWrote || !route.R.ProtoAtLeast(1, 1) {
+		return
+	}
+
+	header := route.W.w.Header()
+	for _, link := range links {
+		header.Add("Link", preloadLink(link))
+	}
+
+	route.W.w.WriteHeader(http.StatusEarlyHints)
+}
+
+// preloadLink returns the Link header value to preload the given
+// link, guessing the destination type from the extension
+func preloadLink(link string) string {
+	if strings.HasPrefix(link, "<") {
+		return link
+	}
+
+	value := "<" + link + ">; rel=preload"
+
+	ext := strings.ToLower(path.Ext(strings.SplitN(link, "?", 2)[0]))
+	switch ext {
+	case ".css":
+		value += "; as=style"
+	case ".js", ".mjs":
+		value += "; as=script"
+	case ".woff", ".woff2", ".ttf", ".otf":
+		value += "; as=font; crossorigin"
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg", ".ico":
+		value += "; as=image"
+	}
+
+	return value
+}
+
+// ExpectsContinue tells whether the client is waiting for the
+// 100 Continue response before sending the request body
+func (route *Route) ExpectsContinue() bool {
+	return strings.EqualFold(route.R.Header.Get("Expect"), "100-continue")
+}
+
+// CloseConnection tells the client and the underlying http.Server that
+// the connection must be closed after this response, even if
+// keep-alives are enabled
+func (route *Route) CloseConnection() {
+	route.W.Header().Set("Connection", "close")
 }
 
 // ServeCustomFileWithTime will serve a pseudo-file saved in memory specifing the
 // last modification time. The name of the file is important for MIME type detection
 func (route *Route) ServeCustomFileWithTime(fileName string, data []byte, t time.Time) {
-	http.ServeContent(route.W, route.R, fileName, t, bytes.NewReader(data))
+	route.serveContent(fileName, t, bytes.NewReader(data))
 }
 
 // ServeCustomFile serves a pseudo-file saved in memory. The name of the file is
 // important for MIME type detection
 func (route *Route) ServeCustomFile(fileName string, data []byte) {
-	http.ServeContent(route.W, route.R, fileName, time.Now(), bytes.NewReader(data))
+	route.serveContent(fileName, time.Now(), bytes.NewReader(data))
+}
+
+// ServeRangeContent serves a content of the given size that can only be read at
+// specific positions (like a remote object or a generated archive) without needing
+// an io.ReadSeeker: the Range requests are handled automatically, responding with
+// 206 Partial Content and the correct Content-Range header (multiple ranges are
+// served as a multipart response), and readAt is called only for the requested
+// windows. Conditional requests are handled with the modification time, if not zero.
+// The name is used for the MIME type detection, like in Route.ServeCustomFile
+func (route *Route) ServeRangeContent(name string, size int64, modTime time.Time, readAt func(p []byte, off int64) (int, error)) {
+	route.serveContent(name, modTime, io.NewSectionReader(readerAtFunc(readAt), 0, size))
+}
+
+// readerAtFunc implements io.ReaderAt with a function
+type readerAtFunc func(p []byte, off int64) (int, error)
+
+func (f readerAtFunc) ReadAt(p []byte, off int64) (int, error) {
+	return f(p, off)
+}
+
+// serveContent serves the content with http.ServeContent, advertising the
+// support for the ranges with the Accept-Ranges header set to "bytes" (unless
+// they were disabled, see Route.DisableRanges) on every response, even on the
+// ones without the content like 304 Not Modified and 416 Range Not Satisfiable
+func (route *Route) serveContent(name string, modTime time.Time, content io.ReadSeeker) {
+	if !route.W.noRanges {
+		route.W.Header().Set("Accept-Ranges", "bytes")
+	}
+	http.ServeContent(route.W, route.R, name, modTime, content)
 }
 
 // ServeData serves raw bytes to the client
@@ -136,19 +500,230 @@ func (route *Route) ServeText(text string) {
 	route.ServeData([]byte(text))
 }
 
+// Render executes the template with the given name, previously loaded in the
+// subdomain with Subdomain.LoadTemplatesFS, and serves the result. The Content-Type
+// is derived from the template name extension (or from the content itself)
+// and an ETag is generated from the rendered output, so that conditional
+// requests are handled automatically. If the template is not found or fails, an
+// Internal Server Error is reported (and so the error template is served)
+func (route *Route) Render(name string, data any) {
+	var templates *template.Template
+	if route.Subdomain != nil {
+		templates = route.Subdomain.templates.Load()
+	}
+	if templates == nil {
+		route.Error(http.StatusInternalServerError, "Internal server error", "No templates loaded in the subdomain")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		route.Error(http.StatusInternalServerError, "Internal server error", "Error rendering template", name+":", err)
+		return
+	}
+
+	route.W.Header().Set("ETag", fmt.Sprintf("\"%s\"", GenerateHashString(buf.Bytes())[:32]))
+	route.serveContent(name, time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// ServeJSON marshals the value and serves it to the client with
+// the application/json content type. If the marshaling fails,
+// an Internal Server Error is reported instead
+func (route *Route) ServeJSON(value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		route.Error(http.StatusInternalServerError, "Internal server error", "Error marshaling JSON response:", err)
+		return
+	}
+
+	route.W.Header().Set("Content-Type", "application/json")
+	route.ServeData(data)
+}
+
+// ChecksumTrailer is the trailer used by Route.ServeReaderWithChecksum
+// to send the hex-encoded SHA-256 checksum of the response body
+var ChecksumTrailer = "X-Content-SHA256"
+
+// ServeReaderWithChecksum streams the content of the reader to the client
+// while computing its SHA-256 checksum, that is sent at the end of the response
+// in the ChecksumTrailer trailer, so that clients can verify large downloads
+// without knowing the checksum in advance. The trailer is sent only if the client
+// advertises the support for trailers (with the "TE: trailers" request header).
+// The name is used for the MIME type detection, if the Content-Type is not already set.
+//
+// If the reader fails before anything was sent, an Internal Server Error is
+// reported, otherwise the response is truncated and the trailer is not sent.
+// The error is also returned
+func (route *Route) ServeReaderWithChecksum(name string, r io.Reader) error {
+	header := route.W.Header()
+	if header.Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(filepath.Ext(name))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		header.Set("Content-Type", ctype)
+	}
+
+	withTrailer := false
+	for _, value := range route.R.Header.Values("TE") {
+		for _, te := range splitHeaderList(value) {
+			if strings.EqualFold(te, "trailers") {
+				withTrailer = true
+			}
+		}
+	}
+	if withTrailer {
+		header.Set("Trailer", ChecksumTrailer)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(route.W, h), r)
+	if err != nil {
+		if n == 0 && !route.W.hasWrote {
+			header.Del("Trailer")
+			route.Error(http.StatusInternalServerError, "Internal server error", "Error reading content of", name+":", err)
+		} else {
+			route.Logger.Printf(logger.LOG_LEVEL_ERROR, "Stream of %s interrupted after %d bytes: %v", name, n, err)
+		}
+		return err
+	}
+
+	if withTrailer {
+		header.Set(ChecksumTrailer, hex.EncodeToString(h.Sum(nil)))
+	}
+
+	return nil
+}
+
+// StreamJSONFlushInterval is the number of items written by Route.StreamJSONArray
+// after which the response is flushed to the client
+var StreamJSONFlushInterval = 100
+
+// StreamJSONArray writes a JSON array to the client one item at a time, without
+// building it in memory. The function f is called once and receives an encode function:
+// every call writes the provided value as the next item of the array. The response
+// is flushed periodically (see StreamJSONFlushInterval).
+//
+// If f returns an error after the first item was written, the array is closed
+// anyway (so the client receives a truncated result) and the error is logged,
+// otherwise an Internal Server Error is reported. The error is also returned
+func (route *Route) StreamJSONArray(f func(encode func(v any) error) error) error {
+	route.W.Header().Set("Content-Type", "application/json")
+
+	var count int
+	var writeErr error
+	encode := func(v any) error {
+		if writeErr != nil {
+			return writeErr
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		prefix := ","
+		if count == 0 {
+			prefix = "["
+		}
+
+		if _, writeErr = route.W.Write(append([]byte(prefix), data...)); writeErr != nil {
+			return writeErr
+		}
+
+		count++
+		if count%StreamJSONFlushInterval == 0 {
+			route.W.Flush()
+		}
+
+		return nil
+	}
+
+	err := f(encode)
+	if err != nil && count == 0 {
+		route.Error(http.StatusInternalServerError, "Internal server error", "Error streaming JSON array:", err)
+		return err
+	}
+
+	if count == 0 {
+		route.W.Write([]byte("[]"))
+	} else {
+		route.W.Write([]byte("]"))
+	}
+
+	if err != nil {
+		route.Logger.Printf(logger.LOG_LEVEL_ERROR, "JSON array stream interrupted after %d items: %v", count, err)
+	}
+
+	return err
+}
+
+// MultiStatusItem is the result of a single operation inside a
+// MultiStatus response
+type MultiStatusItem struct {
+	Status  int `json:"status"`
+	Payload any `json:"payload,omitempty"`
+}
+
+// MultiStatus collects the results of the operations performed by
+// a batch request, so that they can be served all at once with
+// Route.ServeMultiStatus
+type MultiStatus struct {
+	Items []MultiStatusItem `json:"items"`
+}
+
+// Add adds the result of an operation with its status code and payload
+func (ms *MultiStatus) Add(status int, payload any) *MultiStatus {
+	ms.Items = append(ms.Items, MultiStatusItem{Status: status, Payload: payload})
+	return ms
+}
+
+// ServeMultiStatus serves the collected results as a JSON object with the
+// 207 Multi-Status code. Every single result can have its own status code,
+// even an error one, but the overall response is not treated as an error:
+// the body is not captured and replaced with the error template and the
+// connection is logged as a successful one
+func (route *Route) ServeMultiStatus(ms *MultiStatus) {
+	if ms.Items == nil {
+		ms.Items = []MultiStatusItem{}
+	}
+
+	data, err := json.Marshal(ms)
+	if err != nil {
+		route.Error(http.StatusInternalServerError, "Internal server error", "Error marshaling multi-status response:", err)
+		return
+	}
+
+	route.W.Header().Set("Content-Type", "application/json")
+	route.W.WriteHeader(http.StatusMultiStatus)
+	route.ServeData(data)
+}
+
 // StaticServe tries to serve a file for every connection done via
 // a GET request, following all the options provided in the Website
 // configuration. This means it will not serve any file inside (also
 // nested) a hidden folder, it will serve an HTML file only with the
 // flag argument set to true, it will serve index.html automatically
 // for connection with request uri empty or equal to "/", it will serve
-// every file inside the AllFolders field of the Website
+// every file inside the AllFolders field of the Website. The Website
+// DefaultFavicon and RobotsTxt, if set, are served before any file
 func (route *Route) StaticServe(serveHTML bool) {
 	if route.Method != "GET" && route.Method != "HEAD" {
 		route.Error(http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
+	switch {
+	case route.RequestURI == "/favicon.ico" && route.Website.DefaultFavicon != nil:
+		route.CacheControl().Public().MaxAge(time.Hour * 24).Set()
+		route.ServeCustomFileWithTime("favicon.ico", route.Website.DefaultFavicon, time.Time{})
+		return
+	case route.RequestURI == "/robots.txt" && route.Website.RobotsTxt != "":
+		route.CacheControl().Public().MaxAge(time.Hour * 24).Set()
+		route.ServeCustomFileWithTime("robots.txt", []byte(route.Website.RobotsTxt), time.Time{})
+		return
+	}
+
 	for _, s := range route.Website.HiddenFolders {
 		if s == "" || strings.HasPrefix(route.RequestURI, s) {
 			route.Error(http.StatusNotFound, "Not Found")
@@ -176,6 +751,88 @@ func (route *Route) StaticServe(serveHTML bool) {
 	route.Error(http.StatusNotFound, "Not Found")
 }
 
+// MaxCookieSize is the maximum size in bytes of a cookie (name, value and attributes)
+// that can be set with Route.SetCookie and Route.SetCookiePerm. Browsers usually
+// silently discard cookies bigger than 4096 bytes
+var MaxCookieSize = 4096
+
+// ErrCookieTooLarge is returned when a cookie exceeds the MaxCookieSize limit
+var ErrCookieTooLarge = errors.New("cookie exceeds the maximum size")
+
+// cookieName returns the hashed name of the cookie, registering it in
+// the server so that it can be later mapped back (see Route.AppCookieNames).
+// If the server has a cookie salt, it's mixed with the name before hashing
+// (see HTTPServer.SetCookieSalt)
+func (route *Route) cookieName(name string) string {
+	route.Srv.cookieNamesM.RLock()
+	data := []byte(name)
+	if route.Srv.cookieSalt != "" {
+		data = []byte(route.Srv.cookieSalt + "\x00" + name)
+	}
+	hashed := GenerateHashString(data)
+	_, ok := route.Srv.cookieNames[hashed]
+	route.Srv.cookieNamesM.RUnlock()
+
+	if !ok {
+		route.Srv.cookieNamesM.Lock()
+		route.Srv.cookieNames[hashed] = name
+		route.Srv.cookieNamesM.Unlock()
+	}
+
+	return hashed
+}
+
+// AppCookieNames returns the names of the cookies sent by the client that were
+// set by the server with Route.SetCookie or Route.SetCookiePerm. Only the names used
+// by the server since it was created can be mapped back, because the actual cookie
+// names are hashed
+func (route *Route) AppCookieNames() []string {
+	route.Srv.cookieNamesM.RLock()
+	defer route.Srv.cookieNamesM.RUnlock()
+
+	var names []string
+	for _, cookie := range route.R.Cookies() {
+		if name, ok := route.Srv.cookieNames[cookie.Name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// DeleteAllCookies removes every cookie sent by the client that was set by the
+// server, see Route.AppCookieNames
+func (route *Route) DeleteAllCookies() {
+	for _, name := range route.AppCookieNames() {
+		route.DeleteCookie(name)
+	}
+}
+
+// setCookie adds the Set-Cookie header to the response, replacing any other
+// Set-Cookie header with the same cookie name already present. If the
+// cookie exceeds the MaxCookieSize limit, it's not set and an error is returned
+func (route *Route) setCookie(cookie *http.Cookie) error {
+	v := cookie.String()
+	if v == "" {
+		return fmt.Errorf("invalid cookie \"%s\"", cookie.Name)
+	}
+	if len(v) > MaxCookieSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrCookieTooLarge, len(v), MaxCookieSize)
+	}
+
+	header := route.W.Header()
+	setCookies := header.Values("Set-Cookie")
+	filtered := make([]string, 0, len(setCookies)+1)
+	for _, c := range setCookies {
+		if !strings.HasPrefix(c, cookie.Name+"=") {
+			filtered = append(filtered, c)
+		}
+	}
+
+	header["Set-Cookie"] = append(filtered, v)
+	return nil
+}
+
 // SetCookie creates a new cookie with the given name and value, maxAge can be used
 // to sex the expiration date:
 //   - maxAge = 0 means no expiration specified
@@ -189,30 +846,32 @@ func (route *Route) StaticServe(serveHTML bool) {
 // The encoding of the value is managed by the package encoding/gob. If you are just encoding and decoding
 // plain structs and each field type is a primary type or a struct (with the same rules), nothing should be
 // done, but if you are dealing with interfaces, you must first register every concrete structure or type
-// implementing that interface before encoding or decoding
+// implementing that interface before encoding or decoding.
+//
+// If the encoded cookie exceeds the MaxCookieSize limit, the cookie is not set and
+// an ErrCookieTooLarge is returned. Setting a cookie with the same name multiple
+// times in the same response only keeps the last one
 func (route *Route) SetCookie(name string, value any, maxAge int) error {
 	encValue, err := route.Srv.secureCookie.Encode(name, value)
 	if err != nil {
 		return err
 	}
 
-	http.SetCookie(route.W, &http.Cookie{
-		Name:     GenerateHashString([]byte(name)),
+	return route.setCookie(&http.Cookie{
+		Name:     route.cookieName(name),
 		Value:    encValue,
 		Domain:   route.DomainName,
 		MaxAge:   maxAge,
 		Secure:   route.Secure,
 		HttpOnly: route.Secure,
 	})
-
-	return nil
 }
 
 // DeleteCookie instantly removes a cookie with the given name before set with route.SetCookie
 // or route.SetCookiePerm
 func (route *Route) DeleteCookie(name string) {
-	http.SetCookie(route.W, &http.Cookie{
-		Name:     GenerateHashString([]byte(name)),
+	route.setCookie(&http.Cookie{
+		Name:     route.cookieName(name),
 		Value:    "",
 		Domain:   route.DomainName,
 		MaxAge:   -1,
@@ -235,7 +894,7 @@ func (route *Route) DeleteCookie(name string) {
 // be returned. A workaround might be using the type parametric
 // function server.DecodeCookie
 func (route *Route) DecodeCookie(name string, value any) (found bool, err error) {
-	cookie, err := route.R.Cookie(GenerateHashString([]byte(name)))
+	cookie, err := route.R.Cookie(route.cookieName(name))
 	if err != nil {
 		return
 	}
@@ -263,22 +922,22 @@ func DecodeCookie[T any](route *Route, name string) (value T, found bool, err er
 // The cookie value is encoded and encrypted using a pair of keys at package level that MUST be set at
 // program startup. This differs for the method route.SetCookie to ensure that even after server restart
 // these cookies can still be decoded.
+//
+// The same size limit and de-duplication of route.SetCookie apply
 func (route *Route) SetCookiePerm(name string, value any, maxAge int) error {
 	encValue, err := route.Srv.secureCookiePerm.Encode(name, value)
 	if err != nil {
 		return err
 	}
 
-	http.SetCookie(route.W, &http.Cookie{
-		Name:     GenerateHashString([]byte(name)),
+	return route.setCookie(&http.Cookie{
+		Name:     route.cookieName(name),
 		Value:    encValue,
 		Domain:   route.DomainName,
 		MaxAge:   maxAge,
 		Secure:   route.Secure,
 		HttpOnly: route.Secure,
 	})
-
-	return nil
 }
 
 // DecodeCookiePerm decodes a previously set cookie with the given name
@@ -294,7 +953,7 @@ func (route *Route) SetCookiePerm(name string, value any, maxAge int) error {
 // be returned. A workaround might be using the type parametric
 // function server.DecodeCookiePerm
 func (route *Route) DecodeCookiePerm(name string, value any) (found bool, err error) {
-	cookie, err := route.R.Cookie(GenerateHashString([]byte(name)))
+	cookie, err := route.R.Cookie(route.cookieName(name))
 	if err != nil {
 		return
 	}
@@ -313,35 +972,205 @@ func DecodeCookiePerm[T any](route *Route, name string) (value T, found bool, er
 	return
 }
 
+// SetSessionCookie creates a session cookie with the given name and value: the
+// cookie has no expiration, so it's deleted by the browser when the session
+// ends, and it's encrypted with the keys generated at server creation, so it can't
+// be decoded after a server restart. It's the same as calling route.SetCookie
+// with maxAge set to 0. Use route.DecodeCookie to read it
+func (route *Route) SetSessionCookie(name string, value any) error {
+	return route.SetCookie(name, value, 0)
+}
+
+// SetPersistentCookie creates a cookie with the given name and value that
+// expires after the given duration (at least one second): the cookie is encrypted
+// with the permanent keys (see route.SetCookiePerm), so it can still be decoded
+// after a server restart. Use route.DecodeCookiePerm to read it
+func (route *Route) SetPersistentCookie(name string, value any, d time.Duration) error {
+	if d < time.Second {
+		return fmt.Errorf("invalid persistent cookie duration: %v", d)
+	}
+
+	return route.SetCookiePerm(name, value, int(d/time.Second))
+}
+
+// RequestIDHeader is the header used to read the request ID sent by the
+// client (or by another proxy) and to send it back in the response and
+// to the upstream servers with Route.ReverseProxy
+var RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request ID sent by the client
+const maxRequestIDLength = 128
+
+// RequestID returns the ID of the request, used to correlate the logs
+// across different services. If it was not already set with Route.SetRequestID,
+// it's taken from the RequestIDHeader of the request or, if not present or not
+// valid, it's randomly generated. A valid ID is at most 128 characters long and
+// contains only letters, digits and the characters "-", "_", ".", ":".
+// The ID is also set in the response headers
+func (route *Route) RequestID() string {
+	if route.requestID == "" {
+		id := route.R.Header.Get(RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = RandStr(20, ALPHA_LOW_NUM)
+		}
+		route.SetRequestID(id)
+	}
+
+	return route.requestID
+}
+
+// isValidRequestID tells whether the request ID sent
+// by the client can be used, see Route.RequestID
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetRequestID sets the ID of the request, see Route.RequestID
+func (route *Route) SetRequestID(id string) {
+	route.requestID = id
+	route.W.Header().Set(RequestIDHeader, id)
+}
+
 // ReverseProxy runs a reverse proxy to the provided url. Returns an error is the
-// url could not be parsed or if an error has occurred during the connection
+// url could not be parsed or if an error has occurred during the connection.
+// Upstreams listening on a Unix socket can be reached with an url
+// like "unix:///path/to.sock" (see Route.ReverseProxyUnixSocket).
+// The request ID (see Route.RequestID) is forwarded to the upstream with the
+// RequestIDHeader and the upstream response time is added to the connection log.
+//
+// The upstream request is canceled when the client disconnects and when the
+// server proxy timeout expires before the upstream response headers are received
+// (see HTTPServer.SetProxyTimeout): in the last case a 504 Gateway Timeout error
+// is served and no error is returned
 func (route *Route) ReverseProxy(URL string) error {
+	return route.ReverseProxyWithTimeout(URL, route.Srv.proxyTimeout)
+}
+
+// ReverseProxyWithTimeout is like Route.ReverseProxy, but with the
+// given timeout for the upstream request (zero or less means no timeout)
+func (route *Route) ReverseProxyWithTimeout(URL string, timeout time.Duration) error {
 	urlParsed, err := url.Parse(URL)
 	if err != nil {
 		return err
 	}
 
+	var transport http.RoundTripper
+	if urlParsed.Scheme == "unix" {
+		transport = unixSocketTransport(urlParsed.Path)
+		urlParsed = &url.URL{Scheme: "http", Host: "localhost"}
+	}
+
 	proxyServer := httputil.NewSingleHostReverseProxy(urlParsed)
+	proxyServer.Transport = transport
 	proxyLogger := route.Logger.Clone(nil, "proxy")
 	proxyServer.ErrorLog = log.New(proxyLogger, fmt.Sprintf("PROXY [%s]", URL), 0)
 
-	errChan := make(chan error)
-	defer close(errChan)
+	requestID := route.RequestID()
+	director := proxyServer.Director
+	proxyServer.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Set(RequestIDHeader, requestID)
+	}
+
+	ctx, cancel := context.WithCancel(route.R.Context())
+	defer cancel()
+
+	var timedOut atomic.Bool
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	proxyStart := time.Now()
+	proxyServer.ModifyResponse = func(r *http.Response) error {
+		if timer != nil && !timer.Stop() {
+			timedOut.Store(true)
+			return context.DeadlineExceeded
+		}
+
+		route.logRequestURI += fmt.Sprintf(" (upstream %d ms)", time.Since(proxyStart).Milliseconds())
+		return nil
+	}
 
+	var proxyErr error
 	proxyServer.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		errChan <- err
+		if timedOut.Load() && route.R.Context().Err() == nil {
+			route.Error(http.StatusGatewayTimeout, "Gateway timeout", "Upstream", URL, "timed out after", timeout)
+			return
+		}
+		proxyErr = err
 	}
 
-	go func() {
-		proxyServer.ServeHTTP(route.W, route.R)
-		errChan <- nil
-	}()
+	proxyServer.ServeHTTP(route.W, route.R.WithContext(ctx))
+	return proxyErr
+}
 
-	return <-errChan
+// ReverseProxyUnixSocket runs a reverse proxy to the upstream server listening
+// on the Unix socket at the given path, see Route.ReverseProxy. The Host header of
+// the original request is forwarded to the upstream
+func (route *Route) ReverseProxyUnixSocket(socketPath string) error {
+	return route.ReverseProxy("unix://" + socketPath)
+}
+
+// unixTransports holds a transport for every Unix socket
+// used by the reverse proxy, so that connections are reused
+var unixTransports sync.Map
+
+// unixSocketTransport returns the transport that dials
+// the Unix socket at the given path
+func unixSocketTransport(socketPath string) http.RoundTripper {
+	if t, ok := unixTransports.Load(socketPath); ok {
+		return t.(http.RoundTripper)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+
+	actual, _ := unixTransports.LoadOrStore(socketPath, t)
+	return actual.(http.RoundTripper)
+}
+
+// MaxBodySize is the maximum number of bytes that can be read from the body
+// of an incoming request through the Route helpers (RespBody, ReadJSON,
+// JSONDecoder, StreamNDJSON, ...). The limit is applied once to the whole
+// body, so it is cumulative across multiple reads. A value <= 0 disables it
+var MaxBodySize int64
+
+// limitBody wraps the request body with an http.MaxBytesReader the first time
+// it's called, so that every subsequent read shares the same MaxBodySize limit
+func (route *Route) limitBody() {
+	if route.bodyLimited || MaxBodySize <= 0 {
+		return
+	}
+
+	route.R.Body = http.MaxBytesReader(route.W, route.R.Body, MaxBodySize)
+	route.bodyLimited = true
 }
 
 // RespBody returns the response body bytes
 func (route *Route) RespBody() ([]byte, error) {
+	route.limitBody()
 	return io.ReadAll(route.R.Body)
 }
 
@@ -365,6 +1194,38 @@ func ReadJSON[T any](route *Route) (value T, err error) {
 	return value, nil
 }
 
+// JSONDecoder returns a json.Decoder reading directly from the request
+// body, without buffering it in memory first. The body is limited by
+// MaxBodySize (if set)
+func (route *Route) JSONDecoder() *json.Decoder {
+	route.limitBody()
+	return json.NewDecoder(route.R.Body)
+}
+
+// StreamNDJSON can be used to process a request body made of multiple JSON
+// values (like newline-delimited JSON) one at a time. The function f is called
+// once and receives a decode function: every call decodes the next value into
+// the provided pointer and returns io.EOF when there are no more values.
+// Example:
+//
+//	err := route.StreamNDJSON(func(decode func(any) error) error {
+//		for {
+//			var rec Record
+//			err := decode(&rec)
+//			if errors.Is(err, io.EOF) {
+//				return nil
+//			}
+//			if err != nil {
+//				return err
+//			}
+//			// HANDLE THE RECORD
+//		}
+//	})
+func (route *Route) StreamNDJSON(f func(decode func(v any) error) error) error {
+	dec := route.JSONDecoder()
+	return f(dec.Decode)
+}
+
 // IsInternalConn tells wheather the incoming connection should be treated
 // as a local connection. The user can add a filter that can extend this
 // selection to match their needs
@@ -376,11 +1237,65 @@ func (route *Route) IsInternalConn() bool {
 	return route.Router.IsInternalConn(route.RemoteAddress)
 }
 
+// Hostname returns the host requested by the client without the port,
+// also handling IPv6 addresses (returned without the square brackets)
+func (route *Route) Hostname() string {
+	host, _, err := net.SplitHostPort(route.Host)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(route.Host, "["), "]")
+	}
+
+	return host
+}
+
+// RequestPort returns the port requested by the client, taken from the
+// request Host. If the Host does not contain the port, the default one for
+// the scheme of the connection is returned (see Route.Scheme)
+func (route *Route) RequestPort() int {
+	_, p, err := net.SplitHostPort(route.Host)
+	if err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			return port
+		}
+	}
+
+	if route.Scheme() == "https" {
+		return 443
+	}
+	return 80
+}
+
+// Scheme returns the scheme used by the client, "http" or "https". If the
+// connection comes from a trusted proxy, the forwarded scheme is honored
+// (see ProxyConfig)
+func (route *Route) Scheme() string {
+	if route.Secure {
+		return "https"
+	}
+	return "http"
+}
+
+// AbsoluteURL builds the absolute URL for the given path, using the scheme
+// and the host requested by the client. If the connection comes from a trusted
+// proxy, the forwarded scheme and host are honored (see ProxyConfig). The path
+// can contain a query and a fragment
+func (route *Route) AbsoluteURL(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return route.Scheme() + "://" + route.Host + path
+}
+
 var WebsocketUpgrader = websocket.Upgrader {
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 }
 
+// ServeWS upgrades the connection to the WebSocket protocol and calls the handler
+// with the connection, which is closed after the handler returns. The opening and the
+// closing of the connection are logged, the latter with its duration and the bytes received
+// and sent, and the connections open are counted, see Subdomain.ActiveWebSockets
 func (route *Route) ServeWS(wsu websocket.Upgrader, h func(route *Route, conn *websocket.Conn)) {
 	conn, err := wsu.Upgrade(route.W, route.R, nil)
 	if err != nil {
@@ -388,6 +1303,24 @@ func (route *Route) ServeWS(wsu websocket.Upgrader, h func(route *Route, conn *w
 		return
 	}
 
+	start := time.Now()
+	route.Subdomain.wsActive.Add(1)
+	route.Logger.Printf(logger.LOG_LEVEL_INFO, "WebSocket opened by %s on %s", route.RemoteAddress, route.logRequestURI)
+
+	defer func() {
+		conn.Close()
+		active := route.Subdomain.wsActive.Add(-1)
+
+		var read, written int64
+		if c := route.W.hijacked; c != nil {
+			read, written = c.read.Load(), c.written.Load()
+		}
+		route.Logger.Printf(logger.LOG_LEVEL_INFO,
+			"WebSocket of %s on %s closed after %v (in %d bytes, out %d bytes, %d still active)",
+			route.RemoteAddress, route.logRequestURI, time.Since(start).Truncate(time.Millisecond),
+			read, written, active,
+		)
+	}()
+
 	h(route, conn)
-	conn.Close()
 }
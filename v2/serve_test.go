@@ -0,0 +1,377 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteAbsoluteURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		secure     bool
+		target     string
+		remoteAddr string
+		headers    map[string]string
+		path       string
+		want       string
+	}{
+		{
+			name:       "direct connection",
+			target:     "http://example.com/",
+			remoteAddr: "203.0.113.5:1234",
+			path:       "/docs?page=2#top",
+			want:       "http://example.com/docs?page=2#top",
+		},
+		{
+			name:       "direct TLS connection",
+			secure:     true,
+			target:     "https://example.com/",
+			remoteAddr: "203.0.113.5:1234",
+			path:       "docs",
+			want:       "https://example.com/docs",
+		},
+		{
+			name:       "direct connection ignores forwarded headers",
+			target:     "http://example.com/",
+			remoteAddr: "203.0.113.5:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.com"},
+			path:       "/",
+			want:       "http://example.com/",
+		},
+		{
+			name:       "trusted proxy",
+			target:     "http://internal/",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"},
+			path:       "/docs",
+			want:       "https://www.example.com/docs",
+		},
+		{
+			name:       "trusted proxy with Forwarded header",
+			target:     "http://internal/",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"Forwarded": "for=203.0.113.5;proto=https;host=www.example.com"},
+			path:       "/docs",
+			want:       "https://www.example.com/docs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var scheme string
+			c := SubdomainConfig{
+				ServeF: func(route *Route) {
+					scheme = route.Scheme()
+					route.ServeText(route.AbsoluteURL(tt.path))
+				},
+			}
+
+			var srv *HTTPServer
+			if tt.secure {
+				srv = newSecureTestServer(t, c)
+			} else {
+				srv = newTestServer(t, c)
+			}
+			err := srv.SetProxyConfig(ProxyConfig{
+				TrustedProxies: []string{"10.0.0.0/8"},
+				Forwarded:      true, ForwardedProto: true, ForwardedHost: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			if got := doTestRequest(srv, req).Body.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if wantScheme := tt.want[:len(scheme)]; scheme != wantScheme {
+				t.Errorf("got scheme %q, want %q", scheme, wantScheme)
+			}
+		})
+	}
+}
+
+func TestRouteDoneOnClientCancel(t *testing.T) {
+	started := make(chan struct{})
+	done := make(chan struct{})
+
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			close(started)
+			select {
+			case <-route.Done():
+				close(done)
+			case <-time.After(5 * time.Second):
+			}
+		},
+	})
+
+	ts := httptest.NewServer(srv.Server.Handler)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		res, err := ts.Client().Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		errC <- err
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler was not called")
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Route.Done did not fire after the client canceled the request")
+	}
+
+	if err := <-errC; !errors.Is(err, context.Canceled) {
+		t.Errorf("got client error %v, want context.Canceled", err)
+	}
+}
+
+func TestReverseProxyUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "upstream.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+
+	upstream := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "unix")
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.RequestURI(), r.Host, body)
+	})}
+	go upstream.Serve(l)
+	defer upstream.Close()
+
+	proxyFuncs := map[string]func(route *Route) error{
+		"unix url": func(route *Route) error {
+			return route.ReverseProxy("unix://" + socketPath)
+		},
+		"socket path": func(route *Route) error {
+			return route.ReverseProxyUnixSocket(socketPath)
+		},
+	}
+
+	for name, proxyF := range proxyFuncs {
+		t.Run(name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{
+				ServeF: func(route *Route) {
+					if err := proxyF(route); err != nil {
+						t.Errorf("proxy error: %v", err)
+					}
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/api/items?id=3", strings.NewReader("payload"))
+			rec := doTestRequest(srv, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", rec.Code)
+			}
+			if h := rec.Header().Get("X-Upstream"); h != "unix" {
+				t.Errorf("got X-Upstream %q, want \"unix\"", h)
+			}
+			if got, want := rec.Body.String(), "POST /api/items?id=3 example.com payload"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAcceptRanges(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		disable  bool
+		header   http.Header
+		wantCode int
+		want     string
+	}{
+		{"full", false, nil, http.StatusOK, "bytes"},
+		{"range", false, http.Header{"Range": {"bytes=0-1"}}, http.StatusPartialContent, "bytes"},
+		{"not satisfiable", false, http.Header{"Range": {"bytes=100-"}}, http.StatusRequestedRangeNotSatisfiable, "bytes"},
+		{"not modified", false, http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}}, http.StatusNotModified, "bytes"},
+		{"disabled", true, http.Header{"Range": {"bytes=0-1"}}, http.StatusOK, "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{
+				ServeF: func(route *Route) {
+					if tt.disable {
+						route.DisableRanges()
+					}
+					route.ServeCustomFileWithTime("data.bin", []byte("0123456789"), modTime)
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+
+			rec := doTestRequest(srv, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Accept-Ranges"); got != tt.want {
+				t.Errorf("got Accept-Ranges %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []string
+		method    string
+		wantCode  int
+		wantAllow string
+	}{
+		{"get", []string{"get", "POST"}, http.MethodGet, http.StatusOK, ""},
+		{"head from get", []string{"GET"}, http.MethodHead, http.StatusOK, ""},
+		{"rejected", []string{"get", "POST"}, http.MethodPut, http.StatusMethodNotAllowed, "GET, POST, HEAD"},
+		{"head listed", []string{"HEAD", "GET"}, http.MethodDelete, http.StatusMethodNotAllowed, "HEAD, GET"},
+		{"without get", []string{"POST"}, http.MethodHead, http.StatusMethodNotAllowed, "POST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newEmptyTestServer(t, false)
+			_, sd := srv.RegisterDefaultRoute("Test", SubdomainConfig{
+				Website: Website{Name: "Test", Dir: t.TempDir()},
+				ServeF:  func(route *Route) { route.ServeText("ok") },
+			})
+			sd.SetAllowedMethods(tt.allowed...)
+
+			rec := doTestRequest(srv, httptest.NewRequest(tt.method, "/", nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestReverseProxyTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		w.Write([]byte("head-"))
+		w.(http.Flusher).Flush()
+		if r.URL.Path == "/slow-body" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte("tail"))
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			if err := route.ReverseProxy(upstream.URL); err != nil {
+				route.Error(http.StatusBadGateway, "Bad gateway", err)
+			}
+		},
+	})
+	srv.SetProxyTimeout(50 * time.Millisecond)
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/fast", http.StatusOK, "head-tail"},
+		{"/slow-body", http.StatusOK, "head-tail"},
+		{"/slow-headers", http.StatusGatewayTimeout, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("got body %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    string
+		reuse bool
+	}{
+		{"uuid", "0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"token chars", "svc_a.req:42", true},
+		{"missing", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"max length", strings.Repeat("a", maxRequestIDLength), true},
+		{"space", "abc def", false},
+		{"log injection", "abc\\\" level=admin", false},
+		{"unicode", "idè", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			srv := newTestServer(t, SubdomainConfig{
+				ServeF: func(route *Route) {
+					got = route.RequestID()
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.id != "" {
+				req.Header.Set(RequestIDHeader, tt.id)
+			}
+			rec := doTestRequest(srv, req)
+
+			if (got == tt.id) != tt.reuse {
+				t.Errorf("got ID %q for the client ID %q, reuse %v", got, tt.id, tt.reuse)
+			}
+			if !isValidRequestID(got) {
+				t.Errorf("got invalid ID %q", got)
+			}
+			if h := rec.Header().Get(RequestIDHeader); h != got {
+				t.Errorf("got response header %q, want %q", h, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SitemapMaxURLs is the maximum number of URLs allowed in a single sitemap file:
+// bigger sitemaps are split in multiple files listed by a sitemap index
+const SitemapMaxURLs = 50000
+
+// Values accepted for SitemapURL.ChangeFreq
+const (
+	SITEMAP_CHANGE_ALWAYS  = "always"
+	SITEMAP_CHANGE_HOURLY  = "hourly"
+	SITEMAP_CHANGE_DAILY   = "daily"
+	SITEMAP_CHANGE_WEEKLY  = "weekly"
+	SITEMAP_CHANGE_MONTHLY = "monthly"
+	SITEMAP_CHANGE_YEARLY  = "yearly"
+	SITEMAP_CHANGE_NEVER   = "never"
+)
+
+// SitemapURL is an entry of a Sitemap. Only the Loc field
+// is required, the others are omitted if not set
+type SitemapURL struct {
+	// Loc is the absolute URL of the page
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string  // ChangeFreq tells how often the page changes, see the SITEMAP_CHANGE_* constants
+	Priority   float64 // Priority is the priority of the page between 0 and 1
+}
+
+// Sitemap is used to build an XML sitemap (see https://www.sitemaps.org)
+// served with Route.ServeSitemap
+type Sitemap struct {
+	URLs []SitemapURL
+	// CacheTTL, if set, caches the generated XML for the given duration
+	// (see Route.ServeCachedFunc), so that it's not generated at every request
+	CacheTTL time.Duration
+}
+
+// NewSitemap returns an empty Sitemap
+func NewSitemap() *Sitemap {
+	return new(Sitemap)
+}
+
+// Add adds the URL to the sitemap and returns the sitemap itself,
+// so that multiple calls can be chained
+func (sm *Sitemap) Add(u SitemapURL) *Sitemap {
+	sm.URLs = append(sm.URLs, u)
+	return sm
+}
+
+// AddURL adds the page with the given absolute URL and last modification
+// time (ignored if zero) to the sitemap, see Sitemap.Add
+func (sm *Sitemap) AddURL(loc string, lastMod time.Time) *Sitemap {
+	return sm.Add(SitemapURL{Loc: loc, LastMod: lastMod})
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name        `xml:"urlset"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	URLs    []sitemapURLXML `xml:"url"`
+}
+
+type sitemapURLXML struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name          `xml:"sitemapindex"`
+	XMLNS    string            `xml:"xmlns,attr"`
+	Sitemaps []sitemapIndexXML `xml:"sitemap"`
+}
+
+type sitemapIndexXML struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+const sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// pages returns the number of sitemap files needed for the URLs
+func (sm *Sitemap) pages() int {
+	return (len(sm.URLs) + SitemapMaxURLs - 1) / SitemapMaxURLs
+}
+
+// marshalPage returns the XML of the sitemap file with the given
+// page number, starting from 1
+func (sm *Sitemap) marshalPage(page int) ([]byte, error) {
+	start := (page - 1) * SitemapMaxURLs
+	end := start + SitemapMaxURLs
+	if end > len(sm.URLs) {
+		end = len(sm.URLs)
+	}
+
+	set := sitemapURLSet{XMLNS: sitemapXMLNS}
+	for _, u := range sm.URLs[start:end] {
+		entry := sitemapURLXML{Loc: u.Loc, ChangeFreq: u.ChangeFreq}
+		if !u.LastMod.IsZero() {
+			entry.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+		if u.Priority > 0 {
+			entry.Priority = strconv.FormatFloat(u.Priority, 'f', 1, 64)
+		}
+		set.URLs = append(set.URLs, entry)
+	}
+
+	return marshalSitemapXML(set)
+}
+
+// marshalIndex returns the XML of the sitemap index, pointing to the
+// sitemap files served at the given base URL with the page query
+func (sm *Sitemap) marshalIndex(baseURL string) ([]byte, error) {
+	index := sitemapIndex{XMLNS: sitemapXMLNS}
+	for page := 1; page <= sm.pages(); page++ {
+		entry := sitemapIndexXML{Loc: fmt.Sprintf("%s?page=%d", baseURL, page)}
+
+		var lastMod time.Time
+		end := page * SitemapMaxURLs
+		if end > len(sm.URLs) {
+			end = len(sm.URLs)
+		}
+		for _, u := range sm.URLs[(page-1)*SitemapMaxURLs : end] {
+			if u.LastMod.After(lastMod) {
+				lastMod = u.LastMod
+			}
+		}
+		if !lastMod.IsZero() {
+			entry.LastMod = lastMod.UTC().Format(time.RFC3339)
+		}
+
+		index.Sitemaps = append(index.Sitemaps, entry)
+	}
+
+	return marshalSitemapXML(index)
+}
+
+func marshalSitemapXML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ServeSitemap serves the sitemap as XML. If the sitemap contains more than
+// SitemapMaxURLs URLs, a sitemap index is served instead, pointing to the same
+// request URI with the "page" query (like /sitemap.xml?page=2) for each sitemap
+// file. If Sitemap.CacheTTL is set, the generated XML is cached
+// (see Route.ServeCachedFunc), otherwise it's generated at every request
+func (route *Route) ServeSitemap(sm *Sitemap) {
+	page := 0
+	if value, ok := route.QueryMap["page"]; ok {
+		var err error
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 || page > sm.pages() {
+			route.Error(http.StatusNotFound, "Sitemap page not found")
+			return
+		}
+	}
+
+	baseURL := route.AbsoluteURL(route.RequestURI)
+
+	generate := func() ([]byte, string, error) {
+		var data []byte
+		var err error
+
+		switch {
+		case page != 0:
+			data, err = sm.marshalPage(page)
+		case sm.pages() > 1:
+			data, err = sm.marshalIndex(baseURL)
+		default:
+			data, err = sm.marshalPage(1)
+		}
+
+		return data, "application/xml; charset=utf-8", err
+	}
+
+	if sm.CacheTTL > 0 {
+		route.ServeCachedFunc(fmt.Sprintf("sitemap %s?page=%d", baseURL, page), sm.CacheTTL, generate)
+		return
+	}
+
+	data, contentType, err := generate()
+	if err != nil {
+		route.Error(http.StatusInternalServerError, "Internal server error", "Error generating sitemap:", err)
+		return
+	}
+
+	route.W.Header().Set("Content-Type", contentType)
+	route.ServeData(data)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeSitemapIndexURL(t *testing.T) {
+	sm := NewSitemap()
+	for i := 0; i <= SitemapMaxURLs; i++ {
+		sm.AddURL("https://www.example.com/page/"+strconv.Itoa(i), time.Time{})
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct connection",
+			remoteAddr: "203.0.113.5:1234",
+			want:       "http://internal/sitemap.xml?page=2",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "www.example.com"},
+			want:       "https://www.example.com/sitemap.xml?page=2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, SubdomainConfig{
+				ServeF: func(route *Route) {
+					route.ServeSitemap(sm)
+				},
+			})
+			err := srv.SetProxyConfig(ProxyConfig{
+				TrustedProxies: []string{"10.0.0.0/8"},
+				ForwardedProto: true, ForwardedHost: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+			req.Host = "internal"
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			body := doTestRequest(srv, req).Body.String()
+
+			if !strings.Contains(body, "<sitemapindex") {
+				t.Fatalf("expected a sitemap index, got %.200q", body)
+			}
+			if !strings.Contains(body, "<loc>"+tt.want+"</loc>") {
+				t.Errorf("the sitemap index does not point to %s: %.400q", tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sriEntry is the integrity hash of a file, valid until
+// the file modification time or size change
+type sriEntry struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// sriCache contains the integrity hashes computed by Website.SRIHash,
+// indexed by the file path
+var sriCache sync.Map
+
+// SRIHash returns the Subresource Integrity string ("sha384-" followed by the base64
+// SHA-384 digest) of the asset, which path is relative to the Website.Dir (like the
+// request URI used to fetch it), so that it can be used in the integrity attribute of
+// the script and link tags. It can also be used in the templates if the Website is
+// passed in the data, like so:
+//
+//	<script src="/js/app.js" integrity="{{ .Website.SRIHash "/js/app.js" }}" crossorigin="anonymous"></script>
+//
+// The hash is cached and computed again only when the file modification
+// time or size change, so it's always up to date with the served file
+func (ws *Website) SRIHash(assetPath string) (string, error) {
+	filePath, err := ws.cleanPath(ws.Dir + "/" + strings.TrimLeft(assetPath, "/"))
+	if err != nil {
+		return "", err
+	}
+	if err = ws.checkSymlinks(filePath); err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("asset \"%s\" is a directory", assetPath)
+	}
+
+	if value, ok := sriCache.Load(filePath); ok {
+		entry := value.(sriEntry)
+		if entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			return entry.hash, nil
+		}
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha512.New384()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	hash := "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+	sriCache.Store(filePath, sriEntry{modTime: info.ModTime(), size: info.Size(), hash: hash})
+
+	return hash, nil
+}
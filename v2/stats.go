@@ -0,0 +1,216 @@
+package server
+
+import (
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// RouterStats is a snapshot of the state of the Router, of every
+// server registered and of the TaskManager, see Router.Stats
+type RouterStats struct {
+	StartTime  time.Time
+	Uptime     time.Duration
+	Running    bool
+	Goroutines int
+	// MemAlloc is the number of bytes of the allocated heap objects
+	MemAlloc uint64
+	// MemSys is the number of bytes of memory obtained from the OS
+	MemSys      uint64
+	HTTPServers []HTTPServerStats
+	TCPServers  []TCPServerStats
+	Tasks       []TaskStats
+	Processes   []ProcessStats
+}
+
+// RequestStats reports the number of requests handled
+// and how many of them resulted in an error
+type RequestStats struct {
+	Requests     uint64
+	ClientErrors uint64 // ClientErrors counts the responses with a 4xx status code
+	ServerErrors uint64 // ServerErrors counts the responses with a 5xx status code
+}
+
+// ErrorRate returns the fraction of the requests that resulted
+// in a server error (5xx)
+func (stats RequestStats) ErrorRate() float64 {
+	if stats.Requests == 0 {
+		return 0
+	}
+	return float64(stats.ServerErrors) / float64(stats.Requests)
+}
+
+// HTTPServerStats is the snapshot of an HTTPServer, see Router.Stats
+type HTTPServerStats struct {
+	Port   int
+	Secure bool
+	Online bool
+	RequestStats
+	Domains []DomainStats
+}
+
+// DomainStats is the snapshot of the traffic of a Domain, see Router.Stats
+type DomainStats struct {
+	Name string
+	Host string // Host is the domain name used to register the domain
+	RequestStats
+}
+
+// TCPServerStats is the snapshot of a TCPServer, see Router.Stats
+type TCPServerStats struct {
+	Port   int
+	Secure bool
+	Online bool
+}
+
+// TaskStats is the snapshot of a Task, see Router.Stats
+type TaskStats struct {
+	Name            string
+	Timer           time.Duration // Timer is the execution interval (negative if inactive)
+	Ready           bool
+	Running         bool
+	LastRun         time.Time
+	StartupDuration time.Duration
+}
+
+// ProcessStats is the snapshot of a process, see Router.Stats
+type ProcessStats struct {
+	Name    string
+	Running bool
+	PID     int
+}
+
+// requestCounters counts the requests handled by a server or a domain
+type requestCounters struct {
+	requests     atomic.Uint64
+	clientErrors atomic.Uint64
+	serverErrors atomic.Uint64
+}
+
+func (c *requestCounters) add(code int) {
+	c.requests.Add(1)
+	switch {
+	case code >= 500:
+		c.serverErrors.Add(1)
+	case code >= 400:
+		c.clientErrors.Add(1)
+	}
+}
+
+func (c *requestCounters) stats() RequestStats {
+	return RequestStats{
+		Requests:     c.requests.Load(),
+		ClientErrors: c.clientErrors.Load(),
+		ServerErrors: c.serverErrors.Load(),
+	}
+}
+
+// countRequest updates the request counters of the server and of the domain
+func (route *Route) countRequest() {
+	code := route.W.code
+	if code == 0 {
+		code = 200
+	}
+
+	route.Srv.requests.add(code)
+	if route.Domain != nil {
+		route.Domain.requests.add(code)
+	}
+}
+
+// stats returns the snapshot of the task, taken
+// with a single lock of the task state
+func (t *Task) stats() TaskStats {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	return TaskStats{
+		Name:            t.name,
+		Timer:           time.Duration(t.timer),
+		Ready:           t.startupDone,
+		Running:         t.running,
+		LastRun:         t.lastRun,
+		StartupDuration: t.startupTime,
+	}
+}
+
+// Stats returns a snapshot of the Router: the uptime, the memory and goroutines
+// usage, the request counters of every HTTP server and domain, the state of the
+// TCP servers and the state of every task and process of the TaskManager. The
+// request counters are read atomically, so the collection never blocks the
+// requests handling. The result can be directly serialized, for example to JSON
+// (see Router.ServeStats)
+func (router *Router) Stats() RouterStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RouterStats{
+		StartTime:  router.startTime,
+		Running:    router.IsRunning(),
+		Goroutines: runtime.NumGoroutine(),
+		MemAlloc:   mem.Alloc,
+		MemSys:     mem.Sys,
+	}
+	if !router.startTime.IsZero() {
+		stats.Uptime = time.Since(router.startTime)
+	}
+
+	for port, srv := range router.httpServers {
+		srvStats := HTTPServerStats{
+			Port:         port,
+			Secure:       srv.Secure,
+			Online:       srv.Online,
+			RequestStats: srv.requests.stats(),
+		}
+
+		for host, d := range srv.domains {
+			srvStats.Domains = append(srvStats.Domains, DomainStats{
+				Name:         d.Name,
+				Host:         host,
+				RequestStats: d.requests.stats(),
+			})
+		}
+		sort.Slice(srvStats.Domains, func(i, j int) bool { return srvStats.Domains[i].Host < srvStats.Domains[j].Host })
+
+		stats.HTTPServers = append(stats.HTTPServers, srvStats)
+	}
+	sort.Slice(stats.HTTPServers, func(i, j int) bool { return stats.HTTPServers[i].Port < stats.HTTPServers[j].Port })
+
+	for port, srv := range router.tcpServers {
+		stats.TCPServers = append(stats.TCPServers, TCPServerStats{
+			Port:   port,
+			Secure: srv.secure,
+			Online: srv.Online,
+		})
+	}
+	sort.Slice(stats.TCPServers, func(i, j int) bool { return stats.TCPServers[i].Port < stats.TCPServers[j].Port })
+
+	router.TaskMgr.tasksM.RLock()
+	for _, t := range router.TaskMgr.tasks {
+		stats.Tasks = append(stats.Tasks, t.stats())
+	}
+	router.TaskMgr.tasksM.RUnlock()
+	sort.Slice(stats.Tasks, func(i, j int) bool { return stats.Tasks[i].Name < stats.Tasks[j].Name })
+
+	for name := range router.TaskMgr.processes {
+		running, _ := router.TaskMgr.ProcessIsRunning(name)
+		pid, _ := router.TaskMgr.GetProcessPID(name)
+		stats.Processes = append(stats.Processes, ProcessStats{
+			Name:    name,
+			Running: running,
+			PID:     pid,
+		})
+	}
+	sort.Slice(stats.Processes, func(i, j int) bool { return stats.Processes[i].Name < stats.Processes[j].Name })
+
+	return stats
+}
+
+// ServeStats is a ServeFunction that serves the Router stats as JSON (see
+// Router.Stats). It can be used as the serve function of an admin subdomain,
+// or called inside one after checking the client permissions
+func (router *Router) ServeStats(route *Route) {
+	route.CacheControl().NoStore().Set()
+	route.ServeJSON(router.Stats())
+}
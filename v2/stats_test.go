@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsConcurrentTasks(t *testing.T) {
+	router, err := NewRouter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := router.TaskMgr
+
+	newTask := func(name string) {
+		err := tm.NewTask(name, func() (startupF, execF, cleanupF TaskFunc) {
+			startupF = func(tm *TaskManager, t *Task) error { return nil }
+			execF = func(tm *TaskManager, t *Task) error {
+				time.Sleep(time.Millisecond)
+				return nil
+			}
+			return
+		}, TASK_TIMER_INACTIVE)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := tm.StartTask(name); err != nil {
+			t.Error(err)
+		}
+	}
+	newTask("worker")
+	if err := tm.ExecTask("worker"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			stats := router.Stats()
+			if len(stats.Tasks) == 0 || len(stats.Tasks) > 2 {
+				t.Errorf("got %d tasks in the stats", len(stats.Tasks))
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		go tm.ExecTask("worker")
+
+		name := fmt.Sprintf("temp-%d", i)
+		newTask(name)
+		tm.ExecTask(name)
+		tm.RemoveTask(name)
+	}
+	close(stop)
+	wg.Wait()
+
+	stats := router.Stats()
+	if len(stats.Tasks) != 1 {
+		t.Fatalf("got %d tasks in the stats, want 1", len(stats.Tasks))
+	}
+
+	task := stats.Tasks[0]
+	if task.Name != "worker" || !task.Ready || task.LastRun.IsZero() {
+		t.Errorf("unexpected task stats: %+v", task)
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	srv := newTestServer(t, SubdomainConfig{
+		ServeF: func(route *Route) {
+			route.Srv.Router.ServeStats(route)
+		},
+	})
+
+	rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("got Cache-Control %q, want \"no-store\"", cc)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	var stats RouterStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.HTTPServers) != 1 || stats.HTTPServers[0].Requests != 0 {
+		t.Errorf("unexpected http servers stats: %+v", stats.HTTPServers)
+	}
+}
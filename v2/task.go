@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nixpare/comms"
@@ -37,15 +40,20 @@ const (
 // but you can do it manually, see router.SetBackgroundTaskState)
 type Task struct {
 	name        string
-	StartupF    TaskFunc      // StartupF is the function called when the Task is started
-	ExecF       TaskFunc      // ExecF is the function called every time the Task must be executed (from the timer or manually)
-	CleanupF    TaskFunc      // CleanupF is the function called when the Task is removed from the TaskManager or when the TaskManager is stopped (e.g. on Router shutdown)
-	timer       TaskTimer     // TaskTimer is the Task execution interval, that is how often the function ExecF is called
-	exitChan    chan struct{} // exitChan will receive the signal of the server shutting down
-	killChan    chan struct{} // killChan will kill the exec function after the 10 seconds are gone
+	StartupF    TaskFunc  // StartupF is the function called when the Task is started
+	ExecF       TaskFunc  // ExecF is the function called every time the Task must be executed (from the timer or manually)
+	CleanupF    TaskFunc  // CleanupF is the function called when the Task is removed from the TaskManager or when the TaskManager is stopped (e.g. on Router shutdown)
+	timer       TaskTimer // TaskTimer is the Task execution interval, that is how often the function ExecF is called
+	execM       sync.Mutex
+	ctx         context.Context         // ctx is the context of the current execution, canceled when the task must exit
+	cancel      context.CancelCauseFunc // cancel cancels ctx, with ErrTaskStopped as the cause for the exit signal
+	kill        context.CancelFunc      // kill kills the exec function after the 10 seconds are gone
+	killCtx     context.Context
 	startupDone bool
 	running     bool
 	bc          *comms.Broadcaster[struct{}]
+	startupTime time.Duration
+	lastRun     time.Time
 }
 
 // Name returns the name of the function
@@ -53,11 +61,55 @@ func (t *Task) Name() string {
 	return t.name
 }
 
-// ListenForExit waits until the exit signal is received from the manager.
-// This signal is sent when you manually stop a task or the server is shutting
-// down: in the last case the manager will wait for a maximum of 10 seconds,
-// after those, if the execution is not finished, it will first kill the task
-// and then call the cleanup function.
+// ErrTaskStopped is the cause of the cancellation of the task context
+// (see Task.Context) when the task is stopped manually or because the
+// server is shutting down
+var ErrTaskStopped = errors.New("task stopped")
+
+// errTaskDone is the cause of the cancellation of the task
+// context when the exec function has returned
+var errTaskDone = errors.New("task execution terminated")
+
+// canceledContext is returned by Task.Context when the task is not running
+var canceledContext = func() context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errTaskDone)
+	return ctx
+}()
+
+// Context returns the context of the current execution of the exec function:
+// it's canceled when the exit signal is sent by the manager, that is when you
+// manually stop the task or the server is shutting down (in the last case the
+// manager will wait for a maximum of 10 seconds, after those, if the execution
+// is not finished, it will first kill the task and then call the cleanup function),
+// with ErrTaskStopped as the cause (see context.Cause). The context is also canceled
+// when the execution terminates. If the task is not running, the returned context
+// is already canceled. Example:
+//
+//	execF = func(tm *server.TaskManager, t *server.Task) error {
+//		ctx := t.Context()
+//		for {
+//			select {
+//			case <-ctx.Done():
+//				// DO SOME FAST RECOVERY
+//				return nil
+//			case <-time.After(time.Second):
+//				// SOME WORK
+//			}
+//		}
+//	}
+func (t *Task) Context() context.Context {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	if t.ctx == nil {
+		return canceledContext
+	}
+	return t.ctx
+}
+
+// ListenForExit waits until the exit signal is received from the manager,
+// see Task.Context for when it's sent.
 // This function is intended to be called in a goroutine listening for the signal:
 // considering that the goroutine could stay alive even after the task exec function
 // has exited, if this function returns true, this means that the signal is received
@@ -74,21 +126,85 @@ func (t *Task) Name() string {
 //		}()
 //		// SOME LONG RUNNING EXECUTION
 //	}
+//
+// Deprecated: use Task.Context, which can be used with select and
+// passed to the functions accepting a context
 func (t *Task) ListenForExit() bool {
-	_, ok := <-t.exitChan
-	return ok
+	ctx := t.Context()
+	<-ctx.Done()
+	return errors.Is(context.Cause(ctx), ErrTaskStopped)
+}
+
+// sendExit sends the exit signal to the current
+// execution of the exec function, if any
+func (t *Task) sendExit() {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	if t.cancel != nil {
+		t.cancel(ErrTaskStopped)
+	}
+}
+
+// sendKill kills the current execution of the exec function, if any
+func (t *Task) sendKill() {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	if t.kill != nil {
+		t.kill()
+	}
+}
+
+// StartupDuration returns how long the last execution of the
+// startup function took
+func (t *Task) StartupDuration() time.Duration {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	return t.startupTime
+}
+
+// LastRun returns when the exec function was last called
+func (t *Task) LastRun() time.Time {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	return t.lastRun
 }
 
 func (t *Task) IsReady() bool {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
 	return t.startupDone
 }
 
 func (t *Task) IsRunning() bool {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
 	return t.running
 }
 
+// getTimer returns the execution interval of the task
+func (t *Task) getTimer() TaskTimer {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	return t.timer
+}
+
+// setTimer sets the execution interval of the task
+func (t *Task) setTimer(timer TaskTimer) {
+	t.execM.Lock()
+	defer t.execM.Unlock()
+
+	t.timer = timer
+}
+
 func (t *Task) Wait() {
-	if !t.running {
+	if !t.IsRunning() {
 		return
 	}
 
@@ -153,7 +269,13 @@ func (tm *TaskManager) NewTask(name string, f TaskInitFunc, timer TaskTimer) err
 		bc:    comms.NewBroadcaster[struct{}](),
 	}
 
+	tm.tasksM.Lock()
+	if _, ok := tm.tasks[name]; ok {
+		tm.tasksM.Unlock()
+		return fmt.Errorf("task \"%s\" already registered", name)
+	}
 	tm.tasks[name] = t
+	tm.tasksM.Unlock()
 
 	if tm.Router.IsRunning() {
 		tm.startTask(t)
@@ -169,7 +291,7 @@ func (tm *TaskManager) SetTaskTimer(name string, timer TaskTimer) error {
 		return err
 	}
 
-	t.timer = timer
+	t.setTimer(timer)
 	tm.startTask(t)
 	return nil
 }
@@ -216,13 +338,20 @@ func (tm *TaskManager) RemoveTask(name string) error {
 	}
 
 	tm.stopTask(t)
+
+	tm.tasksM.Lock()
 	delete(tm.tasks, name)
+	tm.tasksM.Unlock()
+
 	return nil
 }
 
 // GetTasksNames returns all the names of the registered tasks in the
 // TaskManager
 func (tm *TaskManager) GetTasksNames() []string {
+	tm.tasksM.RLock()
+	defer tm.tasksM.RUnlock()
+
 	names := make([]string, 0, len(tm.tasks))
 	for name := range tm.tasks {
 		names = append(names, name)
@@ -235,17 +364,23 @@ func (tm *TaskManager) GetTasksNames() []string {
 // and then sets the flag Task.startupDone to true. If the function fails it deactivates
 // the task
 func (tm *TaskManager) startTask(t *Task) {
-	if t == nil || t.StartupF == nil || t.startupDone {
+	if t == nil || t.StartupF == nil || t.IsReady() {
 		return
 	}
 
+	start := time.Now()
 	err := logger.PanicToErr(func() error {
 		return t.StartupF(tm, t)
 	})
+	startupTime := time.Since(start)
+
+	t.execM.Lock()
+	t.startupTime = startupTime
+	t.startupDone = err == nil
+	t.execM.Unlock()
 
 	if err == nil {
-		tm.Logger.Printf(logger.LOG_LEVEL_INFO, "Task \"%s\" started successfully", t.name)
-		t.startupDone = true
+		tm.Logger.Printf(logger.LOG_LEVEL_INFO, "Task \"%s\" started successfully in %v", t.name, startupTime)
 		return
 	}
 
@@ -267,22 +402,35 @@ func (tm *TaskManager) startTask(t *Task) {
 // exec function has terminated. It also listens for the kill signal in case the server
 // is shutting down and the task is taking too long to execute
 func (tm *TaskManager) execTask(t *Task) error {
-	if t == nil || t.ExecF == nil || t.running {
+	if t == nil || t.ExecF == nil {
 		return nil
 	}
 
+	t.execM.Lock()
+	if t.running {
+		t.execM.Unlock()
+		return nil
+	}
 	if !t.startupDone {
+		t.execM.Unlock()
 		return fmt.Errorf("can't execute task \"%s\": startup is not done", t.name)
 	}
 
-	t.exitChan = make(chan struct{})
-	t.killChan = make(chan struct{})
+	t.ctx, t.cancel = context.WithCancelCause(context.Background())
+	t.killCtx, t.kill = context.WithCancel(context.Background())
+	killCtx := t.killCtx
 	t.running = true
+	t.lastRun = time.Now()
+	t.execM.Unlock()
 
 	defer func() {
+		t.execM.Lock()
 		t.running = false
-		close(t.exitChan)
-		close(t.killChan)
+		t.cancel(errTaskDone)
+		t.kill()
+		t.ctx, t.cancel = nil, nil
+		t.killCtx, t.kill = nil, nil
+		t.execM.Unlock()
 
 		t.bc.Send(struct{}{})
 	}()
@@ -300,7 +448,7 @@ func (tm *TaskManager) execTask(t *Task) error {
 			return
 		}
 
-		t.timer = TASK_TIMER_INACTIVE
+		t.setTimer(TASK_TIMER_INACTIVE)
 
 		if err.Err != nil {
 			tm.Logger.Printf(logger.LOG_LEVEL_WARNING, "Task \"%s\" exec error: %v", t.name, err.Err)
@@ -318,7 +466,7 @@ func (tm *TaskManager) execTask(t *Task) error {
 	select {
 	case <-execDone:
 		return nil
-	case <-t.killChan:
+	case <-killCtx.Done():
 		tm.Logger.Printf(logger.LOG_LEVEL_ERROR,
 			"Task \"%s\" execution was forcibly killed",
 			t.name,
@@ -329,16 +477,18 @@ func (tm *TaskManager) execTask(t *Task) error {
 
 // stopTask runs the cleanup function, catching every possible error or panic
 func (tm *TaskManager) stopTask(t *Task) {
-	if t == nil || t.CleanupF == nil || !t.startupDone {
+	if t == nil || t.CleanupF == nil || !t.IsReady() {
 		return
 	}
 
-	if t.running {
-		t.exitChan <- struct{}{}
+	if t.IsRunning() {
+		t.sendExit()
 		t.Wait()
 	}
 
+	t.execM.Lock()
 	t.startupDone = false
+	t.execM.Unlock()
 
 	err := logger.PanicToErr(func() error {
 		return t.CleanupF(tm, t)
@@ -363,15 +513,30 @@ func (tm *TaskManager) stopTask(t *Task) {
 }
 
 func (tm *TaskManager) runTasksWithTimer(timer TaskTimer) {
-	for _, t := range tm.tasks {
-		if t.timer == timer {
+	for _, t := range tm.taskList() {
+		if t.getTimer() == timer {
 			go tm.execTask(t)
 		}
 	}
 }
 
+// taskList returns the tasks registered in the TaskManager
+func (tm *TaskManager) taskList() []*Task {
+	tm.tasksM.RLock()
+	defer tm.tasksM.RUnlock()
+
+	tasks := make([]*Task, 0, len(tm.tasks))
+	for _, t := range tm.tasks {
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
 func (tm *TaskManager) getTask(name string) (*Task, error) {
+	tm.tasksM.RLock()
 	t := tm.tasks[name]
+	tm.tasksM.RUnlock()
+
 	if t == nil {
 		return nil, fmt.Errorf("task \"%s\" not found", name)
 	}
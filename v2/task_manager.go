@@ -1,6 +1,7 @@
 package server
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -15,12 +16,25 @@ type TaskManager struct {
 	Logger    *logger.Logger
 	state     *LifeCycle
 	processes map[string]*process.Process
+	captures  map[string]*ringBuffer
+	capturesM sync.Mutex
 	tasks     map[string]*Task
+	tasksM    sync.RWMutex
 	ticker10s *time.Ticker
 	ticker1m  *time.Ticker
 	ticker10m *time.Ticker
 	ticker30m *time.Ticker
 	ticker1h  *time.Ticker
+
+	// StartupConcurrency is the maximum number of tasks that can run their
+	// startup function at the same time when the TaskManager is started.
+	// A value <= 0 means no limit
+	StartupConcurrency int
+	// StartupTimeout is the maximum amount of time the TaskManager waits for
+	// the tasks startup when it's started: after that, the tasks still starting
+	// are logged and the startup continues in the background. A value <= 0
+	// means no timeout
+	StartupTimeout time.Duration
 }
 
 func (router *Router) newTaskManager() {
@@ -29,6 +43,7 @@ func (router *Router) newTaskManager() {
 		Logger:    router.Logger.Clone(nil, "tasks"),
 		state: NewLifeCycleState(),
 		processes: make(map[string]*process.Process), tasks: make(map[string]*Task),
+		captures:  make(map[string]*ringBuffer),
 		ticker10s: time.NewTicker(time.Second * 10), ticker1m: time.NewTicker(time.Minute),
 		ticker10m: time.NewTicker(time.Minute * 10), ticker30m: time.NewTicker(time.Minute * 30),
 		ticker1h: time.NewTicker(time.Hour),
@@ -41,17 +56,7 @@ func (tm *TaskManager) start() {
 	}
 	tm.state.SetState(LCS_STARTING)
 
-	wg := new(sync.WaitGroup)
-	for _, t := range tm.tasks {
-		wg.Add(1)
-		go func(task *Task) {
-			tm.startTask(task)
-			wg.Done()
-		}(t)
-	}
-
-	wg.Wait()
-	tm.Logger.Print(logger.LOG_LEVEL_INFO, "Tasks startup completed")
+	tm.startAllTasks()
 
 	go func() {
 		for tm.state.GetState() == LCS_STARTED {
@@ -72,6 +77,71 @@ func (tm *TaskManager) start() {
 	tm.state.SetState(LCS_STARTED)
 }
 
+// startAllTasks runs the startup function of every task, respecting
+// the StartupConcurrency and StartupTimeout settings
+func (tm *TaskManager) startAllTasks() {
+	var sem chan struct{}
+	if tm.StartupConcurrency > 0 {
+		sem = make(chan struct{}, tm.StartupConcurrency)
+	}
+
+	tasks := tm.taskList()
+
+	m := new(sync.Mutex)
+	starting := make(map[string]struct{}, len(tasks))
+	wg := new(sync.WaitGroup)
+
+	for _, t := range tasks {
+		starting[t.name] = struct{}{}
+		wg.Add(1)
+
+		go func(task *Task) {
+			defer wg.Done()
+
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+
+			tm.startTask(task)
+
+			m.Lock()
+			delete(starting, task.name)
+			m.Unlock()
+		}(t)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var timeout <-chan time.Time
+	if tm.StartupTimeout > 0 {
+		timer := time.NewTimer(tm.StartupTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-done:
+		tm.Logger.Print(logger.LOG_LEVEL_INFO, "Tasks startup completed")
+	case <-timeout:
+		m.Lock()
+		names := make([]string, 0, len(starting))
+		for name := range starting {
+			names = append(names, name)
+		}
+		m.Unlock()
+
+		tm.Logger.Printf(logger.LOG_LEVEL_WARNING,
+			"Tasks startup timed out after %v, still starting: %s",
+			tm.StartupTimeout, strings.Join(names, ", "),
+		)
+	}
+}
+
 func (tm *TaskManager) stop() {
 	if tm.state.AlreadyStopped() {
 		return
@@ -93,7 +163,8 @@ func (tm *TaskManager) stopAllTasks() {
 	var stillRunning int
 	wg := new(sync.WaitGroup)
 
-	for _, t := range tm.tasks {
+	tasks := tm.taskList()
+	for _, t := range tasks {
 		stillRunning++
 		wg.Add(1)
 
@@ -112,8 +183,8 @@ func (tm *TaskManager) stopAllTasks() {
 	}
 
 	if counter == 0 {
-		for _, t := range tm.tasks {
-			t.killChan <- struct{}{}
+		for _, t := range tasks {
+			t.sendKill()
 		}
 	}
 
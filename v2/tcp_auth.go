@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/nixpare/logger"
+)
+
+// AuthTimeout is the maximum time a client has to send the
+// token to a connection handler wrapped with RequireAuthToken
+var AuthTimeout = 5 * time.Second
+
+// ErrAuthFailed is the message sent to the clients that
+// provided a wrong token, see RequireAuthToken
+var ErrAuthFailed = errors.New("authentication failed")
+
+// RequireAuthToken wraps the connection handler of a TCPServer so that every client
+// must send the shared secret token as the first message (see WriteFrame) within
+// AuthTimeout, before the connection is passed to the handler. If the token is wrong
+// or missing, the ErrAuthFailed message is sent back and the connection is closed.
+//
+// This prevents anyone able to reach the port (like any local user for a localhost
+// listener) from using the service, like a control or commands channel. The token is
+// compared in constant time, but it travels in clear text on insecure servers: on
+// shared networks the server should be secure (see NewTCPServer) and the token
+// should be long and random (see RandStr)
+func RequireAuthToken(token string, next ConnHandlerFunc) ConnHandlerFunc {
+	return func(srv *TCPServer, conn *Conn) {
+		conn.TCPConn.SetReadDeadline(time.Now().Add(AuthTimeout))
+		data, err := ReadFrame(conn.TCPConn)
+		conn.TCPConn.SetReadDeadline(time.Time{})
+
+		if err != nil || subtle.ConstantTimeCompare(data, []byte(token)) != 1 {
+			srv.Logger.Printf(logger.LOG_LEVEL_WARNING, "Authentication failed for client %s", conn.RemoteAddr)
+			WriteFrame(conn.TCPConn, []byte(ErrAuthFailed.Error()))
+			conn.TCPConn.Close()
+			return
+		}
+
+		next(srv, conn)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nixpare/logger"
+)
+
+// MaxFrameSize is the maximum size of a message read with ReadFrame
+var MaxFrameSize uint32 = 16 * 1024 * 1024
+
+// ErrFrameTooLarge is returned by ReadFrame when the message length
+// exceeds MaxFrameSize
+var ErrFrameTooLarge = errors.New("frame exceeds the maximum size")
+
+// ReadFrame reads a length-prefixed message: the length is encoded
+// as a 4 bytes big-endian unsigned integer followed by the message itself
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	if length > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrFrameTooLarge, length, MaxFrameSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// WriteFrame writes a length-prefixed message, see ReadFrame
+func WriteFrame(w io.Writer, data []byte) error {
+	if uint64(len(data)) > uint64(MaxFrameSize) {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrFrameTooLarge, len(data), MaxFrameSize)
+	}
+
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadLine reads a newline-delimited message, removing the
+// trailing "\n" or "\r\n". If the connection is closed after
+// a last line without the line feed, the line is returned anyway
+func ReadLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// LineProtocolHandler returns a ConnHandlerFunc implementing a simple
+// newline-delimited protocol: every line received is passed to the function f
+// and the returned response is sent back followed by a line feed. If the function
+// returns an error, it's logged and the connection is closed. For example, this
+// is an echo server:
+//
+//	srv.ConnHandler = server.LineProtocolHandler(func(line string) (string, error) {
+//		return line, nil
+//	})
+func LineProtocolHandler(f func(line string) (string, error)) ConnHandlerFunc {
+	return func(srv *TCPServer, conn *Conn) {
+		defer conn.TCPConn.Close()
+		r := bufio.NewReader(conn.TCPConn)
+
+		for {
+			line, err := ReadLine(r)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					srv.Logger.Printf(logger.LOG_LEVEL_WARNING, "Error reading from %s: %v", conn.RemoteAddr, err)
+				}
+				return
+			}
+
+			resp, err := f(line)
+			if err != nil {
+				srv.Logger.Printf(logger.LOG_LEVEL_WARNING, "Line protocol error with %s: %v", conn.RemoteAddr, err)
+				return
+			}
+
+			if _, err = io.WriteString(conn.TCPConn, resp+"\n"); err != nil {
+				srv.Logger.Printf(logger.LOG_LEVEL_WARNING, "Error writing to %s: %v", conn.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
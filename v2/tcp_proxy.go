@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/nixpare/logger"
+)
+
+// TCPProxyOptions configures the TCP proxy created with TCPServer.ProxyTo
+type TCPProxyOptions struct {
+	// DialTimeout is the maximum time to wait for the connection to the
+	// backend to be established (default 10 seconds)
+	DialTimeout time.Duration
+	// IdleTimeout closes the connections on which no data was transferred
+	// in both directions for the given time (zero means no timeout)
+	IdleTimeout time.Duration
+	// ProxyProtocol enables the PROXY protocol (version 1) header sent to
+	// the backend before any data, so that it can know the real client address.
+	// The backend must expect the header, otherwise the data will be corrupted
+	ProxyProtocol bool
+}
+
+// ProxyTo sets the connection handler of the server to a TCP proxy that
+// forwards every accepted connection to the backend address (like "10.0.0.5:5432"),
+// copying the data in both directions until both sides are done. When one side
+// closes its writing half, the close is propagated to the other one, so protocols
+// relying on half-closed connections keep working
+func (srv *TCPServer) ProxyTo(backend string, opts TCPProxyOptions) {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = time.Second * 10
+	}
+
+	srv.ConnHandler = func(srv *TCPServer, conn *Conn) {
+		defer conn.TCPConn.Close()
+
+		backendConn, err := net.DialTimeout("tcp", backend, opts.DialTimeout)
+		if err != nil {
+			srv.Logger.Printf(logger.LOG_LEVEL_ERROR, "TCP proxy to %s failed for %s: %v", backend, conn.RemoteAddr, err)
+			return
+		}
+		defer backendConn.Close()
+
+		if opts.ProxyProtocol {
+			if _, err := io.WriteString(backendConn, proxyProtocolV1Header(conn.TCPConn)); err != nil {
+				srv.Logger.Printf(logger.LOG_LEVEL_ERROR, "TCP proxy to %s failed for %s: %v", backend, conn.RemoteAddr, err)
+				return
+			}
+		}
+
+		client, upstream := conn.TCPConn, backendConn
+		if opts.IdleTimeout > 0 {
+			client = &idleTimeoutConn{Conn: client, timeout: opts.IdleTimeout}
+			upstream = &idleTimeoutConn{Conn: upstream, timeout: opts.IdleTimeout}
+		}
+
+		done := make(chan struct{}, 2)
+		pipe := func(dst, src net.Conn) {
+			io.Copy(dst, src)
+			closeWrite(dst)
+			done <- struct{}{}
+		}
+
+		go pipe(upstream, client)
+		go pipe(client, upstream)
+
+		<-done
+		<-done
+	}
+}
+
+// proxyProtocolV1Header returns the PROXY protocol version 1
+// header describing the client connection
+func proxyProtocolV1Header(conn net.Conn) string {
+	src, ok1 := conn.RemoteAddr().(*net.TCPAddr)
+	dst, ok2 := conn.LocalAddr().(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return "PROXY UNKNOWN\r\n"
+	}
+
+	proto := "TCP4"
+	if src.IP.To4() == nil || dst.IP.To4() == nil {
+		proto = "TCP6"
+	}
+
+	return fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.IP, dst.IP, src.Port, dst.Port)
+}
+
+// closeWrite closes the writing half of the connection, if
+// supported, otherwise the whole connection is closed
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(*idleTimeoutConn); ok {
+		conn = c.Conn
+	}
+
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+
+	conn.Close()
+}
+
+// idleTimeoutConn extends the deadline of the connection
+// every time some data is read or written
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
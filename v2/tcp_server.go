@@ -7,82 +7,114 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/nixpare/logger"
 )
 
 type Conn struct {
-	TCPConn net.Conn
+	TCPConn    net.Conn
 	RemoteAddr string
 }
 
 type ConnHandlerFunc func(srv *TCPServer, conn *Conn)
 
 type TCPServer struct {
-	listener net.Listener
-	address string
-	port int
-	secure bool
-	Online bool
-	state *LifeCycle
+	listener    net.Listener
+	listenerM   sync.Mutex
+	acceptDone  chan struct{}
+	address     string
+	port        int
+	secure      bool
+	tlsConfig   *tls.Config
+	Online      bool
+	state       *LifeCycle
 	ConnHandler ConnHandlerFunc
-	Router  *Router
-	Logger  *logger.Logger
+	Router      *Router
+	Logger      *logger.Logger
 }
 
 func NewTCPServer(address string, port int, secure bool, certs ...Certificate) (*TCPServer, error) {
-	var listener net.Listener
-	var err error
-
-	listenAddr := fmt.Sprintf("%s:%d", address, port)
+	srv := &TCPServer{
+		address: address,
+		port:    port,
+		secure:  secure,
+		state:   NewLifeCycleState(),
+		Logger:  logger.DefaultLogger,
+	}
 
 	if secure {
-		tslConfig, err := GenerateTSLConfig(certs)
+		var err error
+		srv.tlsConfig, err = GenerateTSLConfig(certs)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		listener, err = tls.Listen("tcp", listenAddr, tslConfig)
-		if err != nil {
-			return nil, err
-		}
+	if err := srv.listen(); err != nil {
+		return nil, err
+	}
+	// a port chosen by the system is kept, so that the
+	// server listens on the same port after a restart
+	srv.port = srv.Port()
+
+	return srv, nil
+}
+
+// listen creates the listener of the server
+func (srv *TCPServer) listen() error {
+	listenAddr := net.JoinHostPort(srv.address, fmt.Sprint(srv.port))
+
+	var listener net.Listener
+	var err error
+	if srv.secure {
+		listener, err = tls.Listen("tcp", listenAddr, srv.tlsConfig)
 	} else {
 		listener, err = net.Listen("tcp", listenAddr)
-		if err != nil {
-			return nil, err
-		}
+	}
+	if err != nil {
+		return err
 	}
 
-	return &TCPServer {
-		listener: listener,
-		address: address,
-		port: port,
-		state: NewLifeCycleState(),
-		Logger: logger.DefaultLogger,
-	}, nil
+	srv.listenerM.Lock()
+	srv.listener = listener
+	srv.listenerM.Unlock()
+	return nil
 }
 
+// Start starts accepting the connections on the listener of the server.
+// The accept loop is bound to the current listener and exits when the
+// listener is closed (see TCPServer.Stop)
 func (srv *TCPServer) Start() {
 	if srv.state.AlreadyStarted() {
 		return
 	}
 
+	srv.listenerM.Lock()
+	listener := srv.listener
+	done := make(chan struct{})
+	srv.acceptDone = done
+	srv.listenerM.Unlock()
+
 	srv.Online = true
 	srv.state.SetState(LCS_STARTED)
 
 	go func() {
-		for srv.state.GetState() == LCS_STARTED {
-			conn, err := srv.listener.Accept()
+		defer close(done)
+
+		for {
+			conn, err := listener.Accept()
 			if err != nil {
-				if !errors.Is(err, net.ErrClosed) {
-					srv.Logger.Print(logger.LOG_LEVEL_ERROR, err)
+				if errors.Is(err, net.ErrClosed) {
+					return
 				}
-				
+
+				srv.Logger.Print(logger.LOG_LEVEL_ERROR, err)
 				continue
 			}
-	
+
 			c := createConn(conn)
-	
+
 			if srv.Online && srv.ConnHandler != nil {
 				go func() {
 					err := logger.PanicToErr(func() error {
@@ -98,19 +130,55 @@ func (srv *TCPServer) Start() {
 	}()
 }
 
+// Stop closes the listener of the server and waits for the accept loop
+// to exit. The connections already accepted are not closed
 func (srv *TCPServer) Stop() error {
 	srv.state.SetState(LCS_STOPPING)
 	srv.Online = false
 
 	defer srv.state.SetState(LCS_STOPPED)
-	return srv.listener.Close()
+
+	srv.listenerM.Lock()
+	listener, done := srv.listener, srv.acceptDone
+	srv.acceptDone = nil
+	srv.listenerM.Unlock()
+
+	err := listener.Close()
+	if done != nil {
+		<-done
+	}
+	return err
+}
+
+// Restart stops the server, closing the listener, and then starts it
+// again with a new listener on the same address and port
+func (srv *TCPServer) Restart() error {
+	if err := srv.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	if err := srv.listen(); err != nil {
+		return err
+	}
+
+	srv.Start()
+	return nil
 }
 
 func (srv *TCPServer) Address() string {
 	return srv.address
 }
 
+// Port returns the port the server is listening on: if the server
+// was created with port 0, this is the port assigned by the operating system
 func (srv *TCPServer) Port() int {
+	srv.listenerM.Lock()
+	listener := srv.listener
+	srv.listenerM.Unlock()
+
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
 	return srv.port
 }
 
@@ -121,8 +189,8 @@ func (srv *TCPServer) IsSecure() bool {
 func createConn(conn net.Conn) *Conn {
 	remoteAdrr := strings.Split(conn.RemoteAddr().String(), ":")[0]
 
-	return &Conn {
-		TCPConn: conn,
+	return &Conn{
+		TCPConn:    conn,
 		RemoteAddr: remoteAdrr,
 	}
 }
@@ -154,7 +222,7 @@ func TCPProxy(address string, port int) ConnHandlerFunc {
 			done <- struct{}{}
 		}()
 
-		<- done
-		<- done
+		<-done
+		<-done
 	}
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialTCPTestServer connects to the server and returns what it sends
+func dialTCPTestServer(t *testing.T, srv *TCPServer) (string, error) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", srv.Port()), time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(conn)
+	return string(data), err
+}
+
+func TestTCPServerRestart(t *testing.T) {
+	srv, err := NewTCPServer("127.0.0.1", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ConnHandler = func(srv *TCPServer, conn *Conn) {
+		conn.TCPConn.Write([]byte("hello"))
+		conn.TCPConn.Close()
+	}
+
+	srv.Start()
+	defer srv.Stop()
+
+	for i := 0; i < 5; i++ {
+		if got, err := dialTCPTestServer(t, srv); err != nil || got != "hello" {
+			t.Fatalf("restart %d: got %q, %v", i, got, err)
+		}
+
+		oldListener := srv.listener
+		if err := srv.Restart(); err != nil {
+			t.Fatalf("restart %d: %v", i, err)
+		}
+
+		if _, err := oldListener.Accept(); err == nil {
+			t.Fatalf("restart %d: the old listener is still open", i)
+		}
+		if srv.acceptDone == nil {
+			t.Fatalf("restart %d: the accept loop was not started again", i)
+		}
+	}
+
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.acceptDone != nil {
+		t.Error("the accept loop is still tracked after stopping")
+	}
+	if _, err := dialTCPTestServer(t, srv); err == nil {
+		t.Error("the stopped server accepted a connection")
+	}
+}
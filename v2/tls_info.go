@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSInfo reports the parameters negotiated with the client
+// during the TLS handshake, see Route.TLSInfo
+type TLSInfo struct {
+	Version     string // Version is the TLS version, like "TLS 1.3"
+	CipherSuite string // CipherSuite is the name of the cipher suite
+	Protocol    string // Protocol is the application protocol negotiated with ALPN, like "h2"
+	ServerName  string // ServerName is the server name requested by the client with SNI
+	Resumed     bool   // Resumed tells whether the session was resumed
+	HTTP3       bool   // HTTP3 tells whether the request was made using HTTP/3
+}
+
+// TLSInfo returns the parameters of the TLS connection used by the request.
+// If the connection is not secure (for example on plain HTTP servers), the
+// result is nil
+func (route *Route) TLSInfo() *TLSInfo {
+	state := route.R.TLS
+	if state == nil {
+		return nil
+	}
+
+	return &TLSInfo{
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Protocol:    state.NegotiatedProtocol,
+		ServerName:  state.ServerName,
+		Resumed:     state.DidResume,
+		HTTP3:       route.R.ProtoMajor == 3,
+	}
+}
+
+// String returns the version, the cipher suite and the
+// protocol separated by a space, as used in the logs
+func (info *TLSInfo) String() string {
+	s := info.Version + " " + info.CipherSuite
+	if info.HTTP3 {
+		s += " h3"
+	} else if info.Protocol != "" {
+		s += " " + info.Protocol
+	}
+
+	return s
+}
+
+// tlsVersionName returns the name of the TLS version
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
+
+// SetTLSInfoLogging enables or disables the logging of the TLS
+// version, the cipher suite and the protocol of every secure request
+// in the access log of the server, see Route.TLSInfo
+func (srv *HTTPServer) SetTLSInfoLogging(v bool) *HTTPServer {
+	srv.logTLSInfo = v
+	return srv
+}
+
+// prepLogTLSInfo adds the TLS informations of the request to the
+// request URI logged, if enabled in the server
+func (route *Route) prepLogTLSInfo() {
+	if !route.Srv.logTLSInfo {
+		return
+	}
+
+	if info := route.TLSInfo(); info != nil {
+		route.logRequestURI += " [" + info.String() + "]"
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCompressionOverTransports checks that the encoding negotiation and the
+// response headers are the same whether the request is served over HTTP/1.1
+// or HTTP/2, since both protocols go through the same handler
+func TestCompressionOverTransports(t *testing.T) {
+	srv := newCompressionTestServer(t)
+
+	for _, proto := range []string{"HTTP/1.1", "HTTP/2.0"} {
+		t.Run(proto, func(t *testing.T) {
+			ts := httptest.NewUnstartedServer(srv.Server.Handler)
+			ts.EnableHTTP2 = proto == "HTTP/2.0"
+			ts.StartTLS()
+			defer ts.Close()
+
+			client := ts.Client()
+			client.Transport.(*http.Transport).DisableCompression = true
+
+			for _, uri := range []string{"/style.css", "/small.css", "/image.png"} {
+				for _, acceptEncoding := range []string{"gzip", "br, gzip;q=0.5", "", "br", "gzip;q=0"} {
+					req, err := http.NewRequest(http.MethodGet, ts.URL+uri, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if acceptEncoding != "" {
+						req.Header.Set("Accept-Encoding", acceptEncoding)
+					}
+
+					res, err := client.Do(req)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if res.Proto != proto {
+						t.Errorf("got protocol %s, want %s", res.Proto, proto)
+					}
+					if res.StatusCode != http.StatusOK {
+						t.Errorf("%s %q: got status %d", uri, acceptEncoding, res.StatusCode)
+					}
+					checkCompressedResponse(t, res, uri, acceptEncoding)
+					res.Body.Close()
+				}
+			}
+		})
+	}
+}
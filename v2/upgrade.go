@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nixpare/logger"
+)
+
+// InheritedListenersEnv is the environment variable used to pass the
+// listeners of the HTTP servers to the new process started by Router.Upgrade.
+// Its value is a list of "address=fd" pairs separated by semicolons
+var InheritedListenersEnv = "NIXSERVER_LISTENERS"
+
+var (
+	inheritedOnce      sync.Once
+	inheritedM         sync.Mutex
+	inheritedListeners map[string]net.Listener
+)
+
+// loadInheritedListeners creates the listeners from the file descriptors
+// passed by the parent process, if any (see InheritedListenersEnv)
+func loadInheritedListeners() {
+	inheritedListeners = make(map[string]net.Listener)
+
+	value := os.Getenv(InheritedListenersEnv)
+	if value == "" {
+		return
+	}
+	os.Unsetenv(InheritedListenersEnv)
+
+	for _, entry := range strings.Split(value, ";") {
+		addr, fdStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+
+		fd, err := strconv.Atoi(fdStr)
+		if err != nil {
+			continue
+		}
+
+		f := os.NewFile(uintptr(fd), "listener "+addr)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			logger.DefaultLogger.Printf(logger.LOG_LEVEL_ERROR, "Error inheriting listener %s: %v", addr, err)
+			continue
+		}
+
+		inheritedListeners[addr] = l
+	}
+}
+
+// takeInheritedListener returns the listener inherited from the parent process
+// for the given address, if any. Every listener can be taken only once
+func takeInheritedListener(addr string) net.Listener {
+	inheritedOnce.Do(loadInheritedListeners)
+
+	inheritedM.Lock()
+	defer inheritedM.Unlock()
+
+	l := inheritedListeners[addr]
+	delete(inheritedListeners, addr)
+	return l
+}
+
+// hasInheritedListener tells whether a listener was inherited from the
+// parent process for the given address, without taking it
+func hasInheritedListener(addr string) bool {
+	inheritedOnce.Do(loadInheritedListeners)
+
+	inheritedM.Lock()
+	defer inheritedM.Unlock()
+
+	return inheritedListeners[addr] != nil
+}
+
+// listen returns the listener used by the server: if the process was
+// started by Router.Upgrade, the listener of the old process is used
+func (srv *HTTPServer) listen() (net.Listener, error) {
+	l := takeInheritedListener(srv.Server.Addr)
+	if l == nil {
+		var err error
+		l, err = net.Listen("tcp", srv.Server.Addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	srv.listenerM.Lock()
+	srv.listener = l
+	srv.listenerM.Unlock()
+
+	if srv.proxyProtocol {
+		return newProxyProtocolListener(l, srv.proxyProtoTrust), nil
+	}
+	return l, nil
+}
+
+// listenerFile returns a duplicate of the file descriptor of the listener
+// of the server, or nil if the server is not listening
+func (srv *HTTPServer) listenerFile() (*os.File, error) {
+	srv.listenerM.Lock()
+	defer srv.listenerM.Unlock()
+
+	l, ok := srv.listener.(*net.TCPListener)
+	if !ok || !srv.IsRunning() {
+		return nil, nil
+	}
+
+	return l.File()
+}
+
+// Upgrade starts a new process with the current executable (that could have been
+// replaced with a new version) and the same arguments, passing it the open
+// listeners of the HTTP servers: the new process will start accepting connections
+// on them as soon as its servers are started, so no connection is refused during
+// the upgrade. Then this router is stopped, letting the active connections finish.
+// The TCP servers are not handed off. This is not supported on Windows
+func (router *Router) Upgrade() error {
+	if runtime.GOOS == "windows" {
+		return errors.New("listeners handoff is not supported on windows")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var entries []string
+	for _, srv := range router.httpServers {
+		f, err := srv.listenerFile()
+		if err != nil {
+			return fmt.Errorf("error exporting listener %s: %w", srv.Server.Addr, err)
+		}
+		if f == nil {
+			continue
+		}
+
+		// ExtraFiles entry i becomes file descriptor 3+i in the new process
+		entries = append(entries, fmt.Sprintf("%s=%d", srv.Server.Addr, 3+len(files)))
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), InheritedListenersEnv+"="+strings.Join(entries, ";"))
+	cmd.ExtraFiles = files
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting upgraded process: %w", err)
+	}
+	router.Logger.Printf(logger.LOG_LEVEL_INFO, "Upgraded process started with PID %d", cmd.Process.Pid)
+
+	router.upgraded = true
+	router.Stop()
+	return nil
+}
+
+// UpgradeOnSignal calls Router.Upgrade when the process receives
+// one of the given signals (for example syscall.SIGHUP). If the
+// upgrade fails, the error is logged and the router keeps running
+func (router *Router) UpgradeOnSignal(sig ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig...)
+
+	go func() {
+		for range c {
+			if err := router.Upgrade(); err != nil {
+				router.Logger.Printf(logger.LOG_LEVEL_ERROR, "Upgrade failed: %v", err)
+				continue
+			}
+
+			signal.Stop(c)
+			return
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// RegisterUpstream registers the url of an upstream server with the given name,
+// so that the serve functions can proxy the connections to it with Route.ProxyTo
+// without knowing its address. If an upstream with the same name is already registered,
+// its url is replaced: this can be done at runtime, while the router is running,
+// and the following connections will be proxied to the new url. Upstreams on
+// Unix sockets are supported, see Route.ReverseProxy
+func (router *Router) RegisterUpstream(name string, URL string) error {
+	if _, err := url.Parse(URL); err != nil {
+		return fmt.Errorf("invalid upstream \"%s\" url: %w", name, err)
+	}
+
+	router.upstreamsM.Lock()
+	defer router.upstreamsM.Unlock()
+
+	if router.upstreams == nil {
+		router.upstreams = make(map[string]string)
+	}
+	router.upstreams[name] = URL
+	return nil
+}
+
+// RemoveUpstream removes the upstream registered with the given name
+func (router *Router) RemoveUpstream(name string) {
+	router.upstreamsM.Lock()
+	defer router.upstreamsM.Unlock()
+
+	delete(router.upstreams, name)
+}
+
+// Upstream returns the url of the upstream registered with the given name
+func (router *Router) Upstream(name string) (string, bool) {
+	router.upstreamsM.RLock()
+	defer router.upstreamsM.RUnlock()
+
+	URL, ok := router.upstreams[name]
+	return URL, ok
+}
+
+// ProxyTo runs a reverse proxy to the upstream registered in the router with the
+// given name (see Router.RegisterUpstream and Route.ReverseProxy). Returns an error
+// if the upstream is not registered or if the connection to the upstream fails
+func (route *Route) ProxyTo(name string) error {
+	if route.Router == nil {
+		return fmt.Errorf("upstream \"%s\" not registered: the server has no router", name)
+	}
+
+	URL, ok := route.Router.Upstream(name)
+	if !ok {
+		return fmt.Errorf("upstream \"%s\" not registered", name)
+	}
+
+	return route.ReverseProxy(URL)
+}
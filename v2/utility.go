@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io/fs"
 	"math/big"
 	"os"
 	"path/filepath"
@@ -86,6 +87,103 @@ func isAbs(path string) bool {
 	return filepath.IsAbs(path)
 }
 
+// errPathOutsideDir is returned when a path points outside the directory
+// that should contain it
+var errPathOutsideDir = errors.New("path points outside of the website directory")
+
+// isInsideDir tells whether the path is the directory itself or
+// is nested inside of it. Both paths must be already cleaned
+func isInsideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// SafeJoin joins the provided path to the root directory, making sure that
+// the result, after being cleaned, is still contained in the root directory.
+// Absolute paths are treated as relative to the root, so the result can never
+// point outside of it
+func SafeJoin(root, path string) (string, error) {
+	root = filepath.Clean(root)
+
+	joined := filepath.Join(root, filepath.FromSlash("/"+path))
+	if !isInsideDir(root, joined) {
+		return "", errPathOutsideDir
+	}
+
+	return joined, nil
+}
+
+// cleanPath cleans the file path and, if the path is located in the website
+// directory (before being cleaned), checks that it does not point outside of it.
+// Other absolute paths (even the ones sharing a prefix with the directory name,
+// like "/srv/site2" for "/srv/site") are only cleaned
+func (ws *Website) cleanPath(filePath string) (string, error) {
+	dir := filepath.Clean(ws.Dir)
+	cleaned := filepath.Clean(filePath)
+
+	if filePath != ws.Dir && !strings.HasPrefix(filePath, strings.TrimSuffix(ws.Dir, "/")+"/") {
+		return cleaned, nil
+	}
+
+	if !isInsideDir(dir, cleaned) {
+		return "", errPathOutsideDir
+	}
+
+	return cleaned, nil
+}
+
+// checkXFile checks that the target of an XFile entry (relative to the
+// Website.Dir or absolute) is not a directory. A missing target is accepted,
+// because the file could be created later: the check is repeated when serving it
+func (ws *Website) checkXFile(target string) error {
+	if !isAbs(target) {
+		target = ws.Dir + "/" + target
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("target \"%s\" is a directory", target)
+	}
+	return nil
+}
+
+// checkSymlinks checks, if the website has the NoSymlinkEscape option set, that
+// the file path does not escape the website directory after resolving every
+// symbolic link
+func (ws *Website) checkSymlinks(filePath string) error {
+	if !ws.NoSymlinkEscape {
+		return nil
+	}
+
+	dir, err := filepath.EvalSymlinks(ws.Dir)
+	if err != nil {
+		return err
+	}
+
+	realPath, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return err
+	}
+
+	if !isInsideDir(dir, realPath) {
+		return fmt.Errorf("symbolic link escapes the website directory: %w", errPathOutsideDir)
+	}
+
+	return nil
+}
+
 func GenerateTSLConfig(certs []Certificate) (*tls.Config, error) {
 	cfg := &tls.Config{
 		CipherSuites: []uint16{
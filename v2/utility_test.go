@@ -0,0 +1,210 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	tests := []struct {
+		root, path string
+		want       string
+		wantErr    bool
+	}{
+		{"/srv/site", "index.html", "/srv/site/index.html", false},
+		{"/srv/site", "a/b/../c.txt", "/srv/site/a/c.txt", false},
+		{"/srv/site", "/etc/passwd", "/srv/site/etc/passwd", false},
+		{"/srv/site", "", "/srv/site", false},
+		{"/srv/site", "%2e%2e/secret", "/srv/site/%2e%2e/secret", false},
+		{"/srv/site", "..a/file", "/srv/site/..a/file", false},
+		{"/srv/site", "../etc/passwd", "", true},
+		{"/srv/site", "a/../../etc/passwd", "", true},
+		{"/srv/site", "../site2/x", "", true},
+		{"/srv/site/", "../site", "/srv/site", false},
+	}
+
+	for _, tt := range tests {
+		got, err := SafeJoin(filepath.FromSlash(tt.root), tt.path)
+		if tt.wantErr {
+			if !errors.Is(err, errPathOutsideDir) {
+				t.Errorf("SafeJoin(%q, %q) = %q, %v, want errPathOutsideDir", tt.root, tt.path, got, err)
+			}
+			continue
+		}
+
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("SafeJoin(%q, %q) = %q, %v, want %q", tt.root, tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsInsideDir(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		want      bool
+	}{
+		{"/srv/site", "/srv/site", true},
+		{"/srv/site", "/srv/site/index.html", true},
+		{"/srv/site", "/srv/site/a/b/c", true},
+		{"/srv/site", "/srv/site/..a", true},
+		{"/srv/site", "/srv/site2/x", false},
+		{"/srv/site", "/srv/site2", false},
+		{"/srv/site", "/srv", false},
+		{"/srv/site", "/etc/passwd", false},
+		{"/srv/site", "site/x", false},
+	}
+
+	for _, tt := range tests {
+		if got := isInsideDir(filepath.FromSlash(tt.dir), filepath.FromSlash(tt.path)); got != tt.want {
+			t.Errorf("isInsideDir(%q, %q) = %v, want %v", tt.dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	ws := &Website{Dir: "/srv/site"}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"/srv/site/index.html", "/srv/site/index.html", false},
+		{"/srv/site/a/../b.html", "/srv/site/b.html", false},
+		{"/srv/site", "/srv/site", false},
+		{"/srv/site/../secret.txt", "", true},
+		{"/srv/site/a/../../site2/x", "", true},
+		{"/srv/site2/x", "/srv/site2/x", false},
+		{"/srv/site2/../site/x", "/srv/site/x", false},
+		{"/etc/passwd", "/etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		got, err := ws.cleanPath(tt.path)
+		if tt.wantErr {
+			if !errors.Is(err, errPathOutsideDir) {
+				t.Errorf("cleanPath(%q) = %q, %v, want errPathOutsideDir", tt.path, got, err)
+			}
+			continue
+		}
+
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+// newTraversalTestDirs creates a website directory with an index file, next to a
+// directory with a secret file, and returns the paths of both directories
+func newTraversalTestDirs(t *testing.T) (site string, outside string) {
+	t.Helper()
+
+	root := t.TempDir()
+	site, outside = root+"/site", root+"/site2"
+	for _, dir := range []string{site, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(site+"/index.txt", []byte("public"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outside+"/secret.txt", []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(root+"/secret.txt", []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return site, outside
+}
+
+func TestServeFileTraversal(t *testing.T) {
+	site, _ := newTraversalTestDirs(t)
+	serveFunctions := map[string]ServeFunction{
+		"static serve": nil,
+		"relative path": func(route *Route) {
+			route.ServeFile(strings.TrimPrefix(route.RequestURI, "/"))
+		},
+	}
+
+	for name, serveF := range serveFunctions {
+		t.Run(name, func(t *testing.T) {
+			testServeFileTraversal(t, newTestServer(t, SubdomainConfig{
+				Website: Website{Dir: site},
+				ServeF:  serveF,
+			}))
+		})
+	}
+}
+
+func testServeFileTraversal(t *testing.T, srv *HTTPServer) {
+	uris := []string{
+		"/../secret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2E%2E/secret.txt",
+		"/..%2fsecret.txt",
+		"/%2e%2e%2fsecret.txt",
+		"/a/%2e%2e/%2e%2e/secret.txt",
+		"/../site2/secret.txt",
+		"/%2e%2e/site2/secret.txt",
+	}
+	for _, uri := range uris {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RequestURI = uri
+		rec := doTestRequest(srv, req)
+
+		if rec.Code == http.StatusOK || strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%s: got %d %q, the file outside the website was served", uri, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/index.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "public" {
+		t.Errorf("/index.txt: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNoSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on windows")
+	}
+
+	site, outside := newTraversalTestDirs(t)
+	if err := os.Symlink(outside, site+"/escape"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside+"/secret.txt", site+"/secret.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(site+"/index.txt", site+"/alias.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, noEscape := range []bool{true, false} {
+		srv := newTestServer(t, SubdomainConfig{
+			Website: Website{Dir: site, NoSymlinkEscape: noEscape},
+		})
+
+		for _, uri := range []string{"/escape/secret.txt", "/secret.txt"} {
+			rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, uri, nil))
+			if noEscape && rec.Code != http.StatusNotFound {
+				t.Errorf("NoSymlinkEscape %s: got status %d, want 404", uri, rec.Code)
+			}
+			if !noEscape && rec.Code != http.StatusOK {
+				t.Errorf("%s: got status %d, want 200 without NoSymlinkEscape", uri, rec.Code)
+			}
+		}
+
+		rec := doTestRequest(srv, httptest.NewRequest(http.MethodGet, "/alias.txt", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "public" {
+			t.Errorf("NoSymlinkEscape %v, link inside the website: got %d %q", noEscape, rec.Code, rec.Body.String())
+		}
+	}
+}
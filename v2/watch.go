@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nixpare/logger"
+)
+
+// dirSnapshot summarizes the state of the files inside a directory,
+// so that changes can be detected by polling
+type dirSnapshot struct {
+	files   int
+	size    int64
+	modTime time.Time
+}
+
+// takeDirSnapshot walks the directory and returns its snapshot
+func takeDirSnapshot(dir string) (dirSnapshot, error) {
+	var snap dirSnapshot
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		snap.files++
+		snap.size += info.Size()
+		if info.ModTime().After(snap.modTime) {
+			snap.modTime = info.ModTime()
+		}
+		return nil
+	})
+
+	return snap, err
+}
+
+// WatchAndReload registers a task in the TaskManager of the Router that every
+// 10 seconds checks the directory for changes (files added, removed or modified)
+// and, when something changed, parses again the templates inside it matching
+// the patterns (see Subdomain.LoadTemplatesFS). If the directory is not absolute,
+// it's relative to the website directory. The templates are loaded once before
+// this method returns, so an error is returned if they are not valid; later
+// parsing errors are logged and the previous templates are kept.
+//
+// Static files are always read from the disk on every request, so they don't
+// need to be reloaded. The server must have been created through a Router
+func (sd *Subdomain) WatchAndReload(dir string, patterns ...string) error {
+	if sd.domain == nil || sd.domain.srv.Router == nil {
+		return errors.New("the server was not created through a router")
+	}
+	srv := sd.domain.srv
+
+	if !isAbs(dir) {
+		dir = sd.website.Load().Dir + "/" + dir
+	}
+	if len(patterns) == 0 {
+		patterns = []string{"*.html"}
+	}
+
+	last, err := takeDirSnapshot(dir)
+	if err != nil {
+		return fmt.Errorf("error reading watched directory: %w", err)
+	}
+
+	if err := sd.LoadTemplatesFS(os.DirFS(dir), patterns...); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("reload %s%s:%d", sd.Name, sd.domain.Name, srv.port)
+	return srv.Router.TaskMgr.NewTask(name, func() (_, execF, _ TaskFunc) {
+		execF = func(tm *TaskManager, t *Task) error {
+			snap, err := takeDirSnapshot(dir)
+			if err != nil {
+				return fmt.Errorf("error reading watched directory: %w", err)
+			}
+			if snap == last {
+				return nil
+			}
+			last = snap
+
+			if err := sd.LoadTemplatesFS(os.DirFS(dir), patterns...); err != nil {
+				return err
+			}
+
+			srv.Logger.Printf(logger.LOG_LEVEL_INFO, "Templates in %s reloaded", dir)
+			return nil
+		}
+		return
+	}, TASK_TIMER_10_SECONDS)
+}
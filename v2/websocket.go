@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingConn is a net.Conn that counts the bytes read and written
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Hijack implements the http.Hijacker interface, if the underlying
+// http.ResponseWriter supports it, letting the caller take over the
+// connection (like for the WebSocket connections, see Route.ServeWS).
+// The bytes read and written on the hijacked connection are counted
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the underlying http.ResponseWriter does not implement http.Hijacker")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.hijacked = &countingConn{Conn: conn}
+	w.hasWrote = true
+	w.headerSent = true
+	if w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+
+	return w.hijacked, rw, nil
+}
+
+// ActiveWebSockets returns the number of WebSocket connections
+// of the subdomain currently open, see Route.ServeWS
+func (sd *Subdomain) ActiveWebSockets() int64 {
+	return sd.wsActive.Load()
+}